@@ -0,0 +1,268 @@
+// Package chart loads and packages helm charts.
+package chart
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+type chartFile struct {
+	Name    string
+	Mode    int64
+	ModTime time.Time
+	Data    []byte
+}
+
+// Chart is a helm chart loaded from either a chart directory or a packaged
+// tarball, ready to be (re)packaged.
+type Chart struct {
+	Name    string
+	Version string
+
+	files   []chartFile
+	archive []byte
+}
+
+// Load loads a chart from either a chart directory or a packaged tarball.
+func Load(chartPath string) (*Chart, error) {
+	info, err := os.Stat(chartPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var chart *Chart
+	if info.IsDir() {
+		chart, err = loadChartDir(chartPath)
+	} else {
+		chart, err = loadChartArchive(chartPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	chartYAML, err := chart.chartYAML()
+	if err != nil {
+		return nil, err
+	}
+
+	var meta struct {
+		Name    string `yaml:"name"`
+		Version string `yaml:"version"`
+	}
+	if err = yaml.Unmarshal(chartYAML.Data, &meta); err != nil {
+		return nil, err
+	}
+
+	if meta.Name == "" || meta.Version == "" {
+		return nil, fmt.Errorf("Chart.yaml in %q must specify both name and version", chartPath)
+	}
+
+	chart.Name = meta.Name
+	chart.Version = meta.Version
+
+	return chart, nil
+}
+
+func loadChartDir(dir string) (*Chart, error) {
+	rules, err := loadIgnoreRules(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	chart := &Chart{}
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		if rel == "." {
+			return nil
+		}
+
+		if rules.ignored(filepath.ToSlash(rel), info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		chart.files = append(chart.files, chartFile{
+			Name:    filepath.ToSlash(rel),
+			Mode:    int64(info.Mode().Perm()),
+			ModTime: info.ModTime(),
+			Data:    data,
+		})
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return chart, nil
+}
+
+func loadChartArchive(archivePath string) (*Chart, error) {
+	archive, err := ioutil.ReadFile(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a chart directory or gzipped tarball: %v", archivePath, err)
+	}
+	defer gz.Close()
+
+	chart := &Chart{archive: archive}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		// packaged charts nest every file under a top level directory named
+		// after the chart, which is re-added when packaging
+		parts := strings.SplitN(path.Clean(header.Name), "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+
+		chart.files = append(chart.files, chartFile{
+			Name:    parts[1],
+			Mode:    header.Mode,
+			ModTime: header.ModTime,
+			Data:    data,
+		})
+	}
+
+	return chart, nil
+}
+
+func (c *Chart) chartYAML() (*chartFile, error) {
+	for i := range c.files {
+		if c.files[i].Name == "Chart.yaml" {
+			return &c.files[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("No Chart.yaml found")
+}
+
+// SetVersion overrides the version in Chart.yaml, preserving the order of the
+// remaining keys.
+func (c *Chart) SetVersion(version string) error {
+	if version == "" {
+		return fmt.Errorf("Version must not be empty")
+	}
+
+	if version == c.Version {
+		return nil
+	}
+
+	chartYAML, err := c.chartYAML()
+	if err != nil {
+		return err
+	}
+
+	fields := yaml.MapSlice{}
+	if err = yaml.Unmarshal(chartYAML.Data, &fields); err != nil {
+		return err
+	}
+
+	for i := range fields {
+		if fields[i].Key == "version" {
+			fields[i].Value = version
+		}
+	}
+
+	data, err := yaml.Marshal(fields)
+	if err != nil {
+		return err
+	}
+
+	chartYAML.Data = data
+	c.Version = version
+	c.archive = nil
+
+	return nil
+}
+
+// Package returns the chart as a gzipped tarball. Charts loaded from a tarball
+// that have not been modified are returned unchanged.
+func (c *Chart) Package() ([]byte, error) {
+	if c.archive != nil {
+		return c.archive, nil
+	}
+
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+
+	for _, f := range c.files {
+		header := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     path.Join(c.Name, f.Name),
+			Mode:     f.Mode,
+			ModTime:  f.ModTime,
+			Size:     int64(len(f.Data)),
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+
+		if _, err := tw.Write(f.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,117 @@
+package chart
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// HelmIgnore is the name of the file listing the files in a chart directory
+// that should not be packaged.
+const HelmIgnore = ".helmignore"
+
+// vcsDirs are never packaged, whether or not they are listed in .helmignore.
+var vcsDirs = map[string]bool{
+	".git": true,
+	".hg":  true,
+	".svn": true,
+	".bzr": true,
+}
+
+type ignoreRule struct {
+	pattern string
+	negate  bool
+	dirOnly bool
+	// rooted rules match the whole path relative to the chart directory
+	// instead of just the base name
+	rooted bool
+}
+
+// ignoreRules is a parsed .helmignore. It follows the same subset of the
+// .gitignore syntax as helm: blank lines and # comments are skipped, a leading
+// ! re-includes a path, a trailing / only matches directories, and patterns
+// containing a / are matched against the whole path.
+type ignoreRules []ignoreRule
+
+func loadIgnoreRules(dir string) (ignoreRules, error) {
+	f, err := os.Open(filepath.Join(dir, HelmIgnore))
+	if os.IsNotExist(err) {
+		return ignoreRules{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseIgnoreRules(f)
+}
+
+func parseIgnoreRules(r io.Reader) (ignoreRules, error) {
+	rules := ignoreRules{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.Contains(line, "**") {
+			return nil, fmt.Errorf("Invalid %s rule %q: ** is not supported", HelmIgnore, line)
+		}
+
+		rule := ignoreRule{}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+
+		if strings.Contains(line, "/") {
+			rule.rooted = true
+			line = strings.TrimPrefix(line, "/")
+		}
+
+		if _, err := path.Match(line, ""); err != nil {
+			return nil, fmt.Errorf("Invalid %s rule %q: %v", HelmIgnore, line, err)
+		}
+
+		rule.pattern = line
+		rules = append(rules, rule)
+	}
+
+	return rules, scanner.Err()
+}
+
+// ignored reports whether the slash separated path relative to the chart
+// directory should be left out of the package. Later rules take precedence.
+func (rules ignoreRules) ignored(name string, isDir bool) bool {
+	if isDir && vcsDirs[path.Base(name)] {
+		return true
+	}
+
+	ignored := false
+	for _, rule := range rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+
+		target := path.Base(name)
+		if rule.rooted {
+			target = name
+		}
+
+		if matched, _ := path.Match(rule.pattern, target); matched {
+			ignored = !rule.negate
+		}
+	}
+
+	return ignored
+}
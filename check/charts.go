@@ -0,0 +1,73 @@
+package check
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	resource "github.com/jghiloni/helm-resource"
+)
+
+// checkCharts reports the latest version of every chart in the charts list as
+// a single composite version, so that any of them changing emits a new
+// version. The hash covers each chart's digest as well, to catch a version
+// being republished.
+func checkCharts(repo resource.HelmChartRepository, req Request) (Response, error) {
+	latest := []resource.ChartVersion{}
+	hash := sha256.New()
+	for _, name := range req.Source.Charts {
+		allChartVersions, ok := repo.Entries[name]
+		if !ok {
+			return Response{}, fmt.Errorf("No chart %q found", name)
+		}
+
+		chartVersions, err := discover(allChartVersions, req.Source)
+		if err != nil {
+			return Response{}, err
+		}
+
+		if len(chartVersions) == 0 {
+			return Response{}, nil
+		}
+
+		info := chartVersions[len(chartVersions)-1]
+		latest = append(latest, resource.ChartVersion{Chart: name, Version: info.Version})
+		fmt.Fprintf(hash, "%s=%s@%s\n", name, info.Version, info.Digest)
+	}
+
+	current := resource.Version{
+		Version: resource.CompositeVersion(latest),
+		Hash:    hex.EncodeToString(hash.Sum(nil)),
+	}
+
+	if req.Version == nil || *req.Version == current || !pinned(repo, *req.Version) {
+		return Response{current}, nil
+	}
+
+	return Response{*req.Version, current}, nil
+}
+
+// pinned reports whether every chart version in a composite version is still
+// in the repository
+func pinned(repo resource.HelmChartRepository, version resource.Version) bool {
+	versions, err := resource.ParseCompositeVersion(version.Version)
+	if err != nil {
+		return false
+	}
+
+	for _, v := range versions {
+		found := false
+		for _, info := range repo.Entries[v.Chart] {
+			if info.Version == v.Version {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
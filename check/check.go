@@ -1,6 +1,7 @@
 package check
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"sort"
@@ -18,24 +19,113 @@ type Request struct {
 
 type Response []resource.Version
 
-func RunCommand(client resource.HTTPClient, req Request) (Response, error) {
-	repo, err := repository.Fetch(client, req.Source)
+func RunCommand(ctx context.Context, backend repository.Backend, req Request) (Response, error) {
+	repo, err := backend.Index(ctx)
 	if err != nil {
 		return Response{}, err
 	}
 
+	selectors := 0
+	for _, set := range []bool{req.Source.ChartName != "", len(req.Source.Charts) > 0, req.Source.ChartPattern != ""} {
+		if set {
+			selectors++
+		}
+	}
+	if selectors > 1 {
+		return Response{}, fmt.Errorf("Only one of chart, charts and chart_pattern may be set")
+	}
+
+	if len(req.Source.Charts) > 0 {
+		return checkCharts(repo, req)
+	}
+
+	if req.Source.ChartPattern != "" {
+		return checkPattern(repo, req)
+	}
+
 	allChartVersions, ok := repo.Entries[req.Source.ChartName]
 	if !ok {
 		return Response{}, fmt.Errorf("No chart %q found", req.Source.ChartName)
 	}
 
-	sortBy := strings.TrimSpace(req.Source.SortBy)
+	chartVersions, err := discover(allChartVersions, req.Source)
+	if err != nil {
+		return Response{}, err
+	}
+
+	if len(chartVersions) == 0 {
+		return Response{}, nil
+	}
+
+	versions := []resource.Version{}
+	if req.Version != nil {
+		ourVersion := -1
+		for i := range chartVersions {
+			if chartVersions[i].Version == req.Version.Version {
+				ourVersion = i
+				break
+			}
+		}
+
+		if ourVersion == -1 {
+			return []resource.Version{
+				{Version: chartVersions[len(chartVersions)-1].Version},
+			}, nil
+		}
+
+		newVersions := chartVersions[ourVersion:]
+		for _, v := range newVersions {
+			versions = append(versions, resource.Version{
+				Version: v.Version,
+			})
+		}
+
+		return versions, nil
+	}
+
+	for _, v := range chartVersions[initialIndex(len(chartVersions), req.Source.InitialVersions):] {
+		versions = append(versions, resource.Version{
+			Version: v.Version,
+		})
+	}
+
+	return versions, nil
+}
+
+// initialIndex returns the index of the first of the sorted versions to report
+// when there is no cursor, which is only the latest one unless
+// initial_versions asks for more
+func initialIndex(count int, initial resource.InitialVersions) int {
+	if initial == resource.AllVersions || int(initial) >= count {
+		return 0
+	}
+
+	if initial <= 1 {
+		return count - 1
+	}
+
+	return count - int(initial)
+}
+
+// discover returns the versions of a chart that should be reported, ordered
+// from oldest to newest
+func discover(allChartVersions []resource.HelmChartInfo, source resource.Source) ([]resource.HelmChartInfo, error) {
+	sortBy := strings.TrimSpace(source.SortBy)
 	if sortBy == "" {
 		sortBy = "semver"
 	}
 
 	if sortBy != "semver" && sortBy != "created" {
-		return Response{}, fmt.Errorf("Sort criteria is %q, but it must be semver or created", sortBy)
+		return nil, fmt.Errorf("Sort criteria is %q, but it must be semver or created", sortBy)
+	}
+
+	var constraint semver.Range
+	if strings.TrimSpace(source.VersionConstraint) != "" {
+		var err error
+		constraint, err = parseConstraint(source.VersionConstraint)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	chartVersions := []resource.HelmChartInfo{}
@@ -46,9 +136,19 @@ func RunCommand(client resource.HTTPClient, req Request) (Response, error) {
 			continue
 		}
 
-		if req.Source.IncludePreReleases || len(ver.Pre) == 0 {
-			chartVersions = append(chartVersions, info)
+		if !source.IncludePreReleases && len(ver.Pre) > 0 {
+			continue
+		}
+
+		if constraint != nil && !constraint(ver) {
+			continue
+		}
+
+		if source.SkipDeprecated && info.Deprecated {
+			continue
 		}
+
+		chartVersions = append(chartVersions, info)
 	}
 
 	sort.Slice(chartVersions, func(i, j int) bool {
@@ -59,7 +159,7 @@ func RunCommand(client resource.HTTPClient, req Request) (Response, error) {
 				log.Printf("Error parsing semver %q\n", chartVersions[i].Version)
 				return false
 			}
-			if !req.Source.IncludePreReleases && len(v1.Pre) > 0 {
+			if !source.IncludePreReleases && len(v1.Pre) > 0 {
 				return false
 			}
 
@@ -78,33 +178,5 @@ func RunCommand(client resource.HTTPClient, req Request) (Response, error) {
 		return false
 	})
 
-	versions := []resource.Version{}
-	if req.Version != nil {
-		ourVersion := -1
-		for i := range chartVersions {
-			if chartVersions[i].Version == req.Version.Version {
-				ourVersion = i
-				break
-			}
-		}
-
-		if ourVersion == -1 {
-			return []resource.Version{
-				{Version: chartVersions[len(chartVersions)-1].Version},
-			}, nil
-		}
-
-		newVersions := chartVersions[ourVersion:]
-		for _, v := range newVersions {
-			versions = append(versions, resource.Version{
-				Version: v.Version,
-			})
-		}
-
-		return versions, nil
-	}
-
-	return []resource.Version{
-		{Version: chartVersions[len(chartVersions)-1].Version},
-	}, nil
+	return chartVersions, nil
 }
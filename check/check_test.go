@@ -1,16 +1,38 @@
 package check_test
 
 import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/cgi"
 	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	resource "github.com/jghiloni/helm-resource"
 	"github.com/jghiloni/helm-resource/check"
+	"github.com/jghiloni/helm-resource/repository"
 )
 
+// runCheck runs check against the backend for the request's repository
+func runCheck(ctx context.Context, client resource.HTTPClient, req check.Request) (check.Response, error) {
+	backend, err := repository.NewBackend(client, req.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	return check.RunCommand(ctx, backend, req)
+}
+
 func TestPublicRepository(t *testing.T) {
 	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime | log.LUTC)
 	client := &fakeClient{}
@@ -25,7 +47,7 @@ func TestPublicRepository(t *testing.T) {
 	}
 
 	t.Run("It works on a public repo with no cursor", func(t *testing.T) {
-		resp, err := check.RunCommand(client, checkReq)
+		resp, err := runCheck(context.Background(), client, checkReq)
 		if err != nil {
 			t.Fatalf("Unexpected error %v", err)
 		}
@@ -42,7 +64,7 @@ func TestPublicRepository(t *testing.T) {
 	t.Run("It works on a public repo with a cursor", func(t *testing.T) {
 		checkReq.Version = &resource.Version{Version: "10.3.0"}
 
-		resp, err := check.RunCommand(client, checkReq)
+		resp, err := runCheck(context.Background(), client, checkReq)
 		if err != nil {
 			t.Fatalf("Unexpected error %v", err)
 		}
@@ -67,7 +89,7 @@ func TestPublicRepository(t *testing.T) {
 
 	t.Run("It works on a public repo with a bad cursor", func(t *testing.T) {
 		checkReq.Version = &resource.Version{Version: "999.3.0"}
-		resp, err := check.RunCommand(client, checkReq)
+		resp, err := runCheck(context.Background(), client, checkReq)
 		if err != nil {
 			t.Fatalf("Unexpected error %v", err)
 		}
@@ -100,7 +122,7 @@ func TestPrivateRepository(t *testing.T) {
 	}
 
 	t.Run("It works on a private repo with no cursor", func(t *testing.T) {
-		resp, err := check.RunCommand(client, checkReq)
+		resp, err := runCheck(context.Background(), client, checkReq)
 		if err != nil {
 			t.Fatalf("Unexpected error %v", err)
 		}
@@ -118,7 +140,7 @@ func TestPrivateRepository(t *testing.T) {
 		checkReq.Source.Username = ""
 		checkReq.Source.Password = ""
 
-		_, err := check.RunCommand(client, checkReq)
+		_, err := runCheck(context.Background(), client, checkReq)
 		if err == nil {
 			t.Fatalf("An error should have occurred but none did")
 		}
@@ -128,13 +150,1127 @@ func TestPrivateRepository(t *testing.T) {
 		checkReq.Source.Username = "garbagein"
 		checkReq.Source.Password = "garbageout"
 
-		_, err := check.RunCommand(client, checkReq)
+		_, err := runCheck(context.Background(), client, checkReq)
 		if err == nil {
 			t.Fatalf("An error should have occurred but none did")
 		}
 	})
 }
 
+func TestVersionConstraint(t *testing.T) {
+	client := &fakeClient{}
+
+	tests := []struct {
+		name       string
+		constraint string
+		cursor     *resource.Version
+		expected   []resource.Version
+	}{
+		{
+			name:       "tilde with no cursor",
+			constraint: "~10.2",
+			expected:   []resource.Version{{Version: "10.2.3"}},
+		},
+		{
+			name:       "partial range with a cursor",
+			constraint: ">=10.3 <11",
+			cursor:     &resource.Version{Version: "10.1.0"},
+			expected:   []resource.Version{{Version: "10.3.0"}},
+		},
+		{
+			name:       "caret with a cursor",
+			constraint: "^11.0.0",
+			cursor:     &resource.Version{Version: "11.0.0"},
+			expected: []resource.Version{
+				{Version: "11.0.0"},
+				{Version: "11.0.1"},
+				{Version: "11.1.0"},
+			},
+		},
+		{
+			name:       "wildcard",
+			constraint: "9.x",
+			expected:   []resource.Version{{Version: "9.1.3"}},
+		},
+		{
+			name:       "comma separated range",
+			constraint: ">= 10.3, <11",
+			expected:   []resource.Version{{Version: "10.3.0"}},
+		},
+		{
+			name:       "partial upper bound includes the whole line",
+			constraint: "<=10.2",
+			expected:   []resource.Version{{Version: "10.2.3"}},
+		},
+		{
+			name:       "partial lower bound excludes the whole line",
+			constraint: ">10.0, <10.2",
+			cursor:     &resource.Version{Version: "10.0.5"},
+			expected:   []resource.Version{{Version: "10.1.0"}},
+		},
+		{
+			name:       "partial major bounds",
+			constraint: ">9, <=10",
+			expected:   []resource.Version{{Version: "10.3.0"}},
+		},
+		{
+			name:       "bare major version",
+			constraint: "11",
+			cursor:     &resource.Version{Version: "11.0.0"},
+			expected: []resource.Version{
+				{Version: "11.0.0"},
+				{Version: "11.0.1"},
+				{Version: "11.1.0"},
+			},
+		},
+		{
+			name:       "exact major version",
+			constraint: "=11",
+			expected:   []resource.Version{{Version: "11.1.0"}},
+		},
+		{
+			name:       "partial version in a range",
+			constraint: "10.2 || 9",
+			expected:   []resource.Version{{Version: "10.2.3"}},
+		},
+		{
+			name:       "match all",
+			constraint: "*",
+			expected:   []resource.Version{{Version: "11.1.0"}},
+		},
+		{
+			name:       "not equal",
+			constraint: "11, !=11.1.0",
+			expected:   []resource.Version{{Version: "11.0.1"}},
+		},
+		{
+			name:       "nothing matches",
+			constraint: ">=99.0.0",
+			expected:   []resource.Version{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := runCheck(context.Background(), client, check.Request{
+				Source: resource.Source{
+					RepositoryURL:     "https://example.com/",
+					ChartName:         "concourse",
+					VersionConstraint: test.constraint,
+				},
+				Version: test.cursor,
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+
+			if len(resp) != len(test.expected) {
+				t.Fatalf("Expected %v but got %v", test.expected, resp)
+			}
+
+			for i := range resp {
+				if resp[i] != test.expected[i] {
+					t.Fatalf("Expected version %q to be %q", resp[i].Version, test.expected[i].Version)
+				}
+			}
+		})
+	}
+
+	for _, constraint := range []string{">=banana", "!=10.3"} {
+		t.Run(fmt.Sprintf("It fails on the invalid constraint %q", constraint), func(t *testing.T) {
+			_, err := runCheck(context.Background(), client, check.Request{
+				Source: resource.Source{
+					RepositoryURL:     "https://example.com/",
+					ChartName:         "concourse",
+					VersionConstraint: constraint,
+				},
+			})
+			if err == nil {
+				t.Fatalf("An error should have occurred but none did")
+			}
+		})
+	}
+}
+
+func TestSkipDeprecated(t *testing.T) {
+	client := &deprecatedClient{
+		deprecated: []string{"11.1.0", "11.0.1"},
+	}
+
+	checkReq := check.Request{
+		Source: resource.Source{
+			RepositoryURL: "https://example.com/",
+			ChartName:     "concourse",
+		},
+	}
+
+	t.Run("It reports deprecated versions by default", func(t *testing.T) {
+		resp, err := runCheck(context.Background(), client, checkReq)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		if len(resp) != 1 || resp[0].Version != "11.1.0" {
+			t.Fatalf("Expected only version 11.1.0 but got %v", resp)
+		}
+	})
+
+	t.Run("It skips deprecated versions", func(t *testing.T) {
+		req := checkReq
+		req.Source.SkipDeprecated = true
+		req.Version = &resource.Version{Version: "10.3.0"}
+
+		resp, err := runCheck(context.Background(), client, req)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		expected := []resource.Version{
+			{Version: "10.3.0"},
+			{Version: "11.0.0"},
+		}
+
+		if len(resp) != len(expected) {
+			t.Fatalf("Expected %v but got %v", expected, resp)
+		}
+
+		for i := range resp {
+			if resp[i] != expected[i] {
+				t.Fatalf("Expected version %q to be %q", resp[i].Version, expected[i].Version)
+			}
+		}
+	})
+}
+
+type deprecatedClient struct {
+	deprecated []string
+}
+
+func (d *deprecatedClient) Do(req *http.Request) (*http.Response, error) {
+	index := chartYAML
+	for _, version := range d.deprecated {
+		index = strings.Replace(index, "    version: "+version+"\n", "    deprecated: true\n    version: "+version+"\n", 1)
+	}
+
+	w := httptest.NewRecorder()
+	w.WriteString(index)
+
+	return w.Result(), nil
+}
+
+func TestCancellation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-req.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := runCheck(ctx, server.Client(), check.Request{
+		Source: resource.Source{
+			RepositoryURL: server.URL,
+			ChartName:     "concourse",
+		},
+	})
+	if err == nil {
+		t.Fatalf("An error should have occurred but none did")
+	}
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the deadline to be exceeded, but got %v", err)
+	}
+
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("The check did not stop when the context was cancelled")
+	}
+}
+
+func TestIndexCache(t *testing.T) {
+	cacheDir, err := ioutil.TempDir(os.TempDir(), "helm-cache-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	tests := []struct {
+		name      string
+		header    string
+		value     string
+		condition string
+	}{
+		{name: "ETag", header: "ETag", value: `"v1"`, condition: "If-None-Match"},
+		{name: "Last-Modified", header: "Last-Modified", value: "Fri, 05 Jun 2020 14:01:19 GMT", condition: "If-Modified-Since"},
+	}
+
+	for _, test := range tests {
+		t.Run("It revalidates the cached index with "+test.name, func(t *testing.T) {
+			fullResponses := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if req.Header.Get(test.condition) == test.value {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+
+				fullResponses++
+				w.Header().Set(test.header, test.value)
+				w.Write([]byte(chartYAML))
+			}))
+			defer server.Close()
+
+			checkReq := check.Request{
+				Source: resource.Source{
+					RepositoryURL: server.URL,
+					ChartName:     "concourse",
+					CacheDir:      cacheDir,
+				},
+			}
+
+			for i := 0; i < 3; i++ {
+				resp, err := runCheck(context.Background(), server.Client(), checkReq)
+				if err != nil {
+					t.Fatalf("Unexpected error %v", err)
+				}
+
+				if len(resp) != 1 || resp[0].Version != "11.1.0" {
+					t.Fatalf("Expected only version 11.1.0 but got %v", resp)
+				}
+			}
+
+			if fullResponses != 1 {
+				t.Fatalf("Expected the index to be downloaded once, but it was downloaded %d times", fullResponses)
+			}
+		})
+	}
+
+	t.Run("It doesn't cache indexes without validators", func(t *testing.T) {
+		emptyDir, err := ioutil.TempDir(os.TempDir(), "helm-cache-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(emptyDir)
+
+		_, err = runCheck(context.Background(), &fakeClient{}, check.Request{
+			Source: resource.Source{
+				RepositoryURL: "https://example.com/",
+				ChartName:     "concourse",
+				CacheDir:      emptyDir,
+			},
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		files, err := ioutil.ReadDir(emptyDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(files) != 0 {
+			t.Fatalf("Expected nothing to be cached, but found %d files", len(files))
+		}
+	})
+}
+
+func TestOCIRegistry(t *testing.T) {
+	registry := &fakeRegistry{}
+	server := httptest.NewTLSServer(registry)
+	defer server.Close()
+	registry.host = server.Listener.Addr().String()
+
+	checkReq := check.Request{
+		Source: resource.Source{
+			RepositoryURL: "oci://" + registry.host + "/charts/concourse",
+			ChartName:     "concourse",
+		},
+	}
+
+	t.Run("It lists tags with no cursor", func(t *testing.T) {
+		resp, err := runCheck(context.Background(), server.Client(), checkReq)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		if len(resp) != 1 {
+			t.Fatalf("There should be exactly 1 version returned, but there were %d", len(resp))
+		}
+
+		if resp[0].Version != "11.1.0+build.1" {
+			t.Fatalf("Expected version %s to be 11.1.0+build.1", resp[0].Version)
+		}
+	})
+
+	t.Run("It lists tags with a cursor and a namespace URL", func(t *testing.T) {
+		req := checkReq
+		req.Source.RepositoryURL = "oci://" + registry.host + "/charts"
+		req.Version = &resource.Version{Version: "11.0.0"}
+
+		resp, err := runCheck(context.Background(), server.Client(), req)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		expected := []resource.Version{
+			{Version: "11.0.0"},
+			{Version: "11.1.0+build.1"},
+		}
+
+		if len(resp) != len(expected) {
+			t.Fatalf("Expected %v but got %v", expected, resp)
+		}
+
+		for i := range resp {
+			if resp[i] != expected[i] {
+				t.Fatalf("Expected version %q to be %q", resp[i].Version, expected[i].Version)
+			}
+		}
+	})
+
+	t.Run("It fails with bad credentials", func(t *testing.T) {
+		req := checkReq
+		req.Source.Username = "garbagein"
+		req.Source.Password = "garbageout"
+
+		_, err := runCheck(context.Background(), server.Client(), req)
+		if err == nil {
+			t.Fatalf("An error should have occurred but none did")
+		}
+	})
+}
+
+type fakeRegistry struct {
+	host string
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		if user, _, ok := req.BasicAuth(); ok && user != "admin" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if req.URL.Query().Get("scope") != "repository:charts/concourse:pull" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		w.Write([]byte(`{"token":"registry-token"}`))
+		return
+	}
+
+	if req.Header.Get("Authorization") != "Bearer registry-token" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="https://`+f.host+`/token",service="fake-registry"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if req.URL.Path != "/v2/charts/concourse/tags/list" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if req.URL.Query().Get("last") == "" {
+		w.Header().Set("Link", `</v2/charts/concourse/tags/list?last=10.3.0&n=3>; rel="next"`)
+		w.Write([]byte(`{"name":"charts/concourse","tags":["8.2.6","10.3.0","latest"]}`))
+		return
+	}
+
+	w.Write([]byte(`{"name":"charts/concourse","tags":["11.0.0","11.1.0_build.1","12.0.0-rc.1"]}`))
+}
+
+func TestGitRepository(t *testing.T) {
+	repo := newGitRepository(t)
+	defer os.RemoveAll(repo.dir)
+
+	repo.commit(map[string]string{
+		"charts/concourse/Chart.yaml":                   "apiVersion: v2\nname: concourse\nversion: 11.1.0\nappVersion: 6.3.0\n",
+		"charts/concourse/charts/postgresql/Chart.yaml": "apiVersion: v2\nname: postgresql\nversion: 8.6.4\n",
+		"charts/concourse/templates/deployment.yaml":    "kind: Deployment\n",
+		"charts/other/Chart.yaml":                       "apiVersion: v2\nname: other\nversion: 1.0.0\n",
+		"README.md":                                     "charts\n",
+	})
+
+	checkRepository := func(version *resource.Version) []resource.Version {
+		cacheDir, err := ioutil.TempDir(os.TempDir(), "helm-cache-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(cacheDir)
+
+		resp, err := runCheck(context.Background(), &fakeClient{}, check.Request{
+			Source: resource.Source{
+				RepositoryURL: "git+file://" + repo.bare + "@charts?ref=main",
+				ChartName:     "concourse",
+				CacheDir:      cacheDir,
+			},
+			Version: version,
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		return resp
+	}
+
+	t.Run("It synthesizes the index from Chart.yaml files", func(t *testing.T) {
+		resp := checkRepository(nil)
+		if len(resp) != 1 || resp[0].Version != "11.1.0" {
+			t.Fatalf("Expected only version 11.1.0 but got %v", resp)
+		}
+	})
+
+	t.Run("It reads an index from the repository", func(t *testing.T) {
+		repo.commit(map[string]string{
+			"charts/index.yaml": chartYAML,
+		})
+
+		resp := checkRepository(&resource.Version{Version: "11.0.1"})
+		if len(resp) != 2 || resp[0].Version != "11.0.1" || resp[1].Version != "11.1.0" {
+			t.Fatalf("Expected versions 11.0.1 and 11.1.0 but got %v", resp)
+		}
+	})
+
+	t.Run("It fetches over HTTPS without credentials on the command line", func(t *testing.T) {
+		gitPath, err := exec.LookPath("git")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// put a git on the PATH that logs the arguments and configured keys
+		// of every command
+		shimDir, err := ioutil.TempDir(os.TempDir(), "helm-git-shim-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(shimDir)
+
+		argsLog := filepath.Join(shimDir, "args.log")
+		keysLog := filepath.Join(shimDir, "keys.log")
+		shim := fmt.Sprintf("#!/bin/sh\necho \"$@\" >> %s\nenv | grep '^GIT_CONFIG_KEY_' >> %s\nexec %s \"$@\"\n", argsLog, keysLog, gitPath)
+		if err = ioutil.WriteFile(filepath.Join(shimDir, "git"), []byte(shim), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		path := os.Getenv("PATH")
+		os.Setenv("PATH", shimDir+string(os.PathListSeparator)+path)
+		defer os.Setenv("PATH", path)
+
+		httpBackend := &cgi.Handler{
+			Path: gitPath,
+			Args: []string{"http-backend"},
+			Env:  []string{"GIT_PROJECT_ROOT=" + repo.dir, "GIT_HTTP_EXPORT_ALL=1"},
+		}
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Header.Get("Authorization") != "Basic YWRtaW46aHVudGVyMg==" || req.Header.Get("X-Api-Key") != "s3cr3t" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			httpBackend.ServeHTTP(w, req)
+		}))
+		defer server.Close()
+
+		cacheDir, err := ioutil.TempDir(os.TempDir(), "helm-cache-")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(cacheDir)
+
+		resp, err := runCheck(context.Background(), &fakeClient{}, check.Request{
+			Source: resource.Source{
+				RepositoryURL: "git+" + server.URL + "/charts.git@charts?ref=main",
+				ChartName:     "concourse",
+				CacheDir:      cacheDir,
+				Username:      "admin",
+				Password:      "hunter2",
+				Headers:       map[string]string{"X-Api-Key": "s3cr3t"},
+				CACert:        string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})),
+			},
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		if len(resp) != 1 || resp[0].Version != "11.1.0" {
+			t.Fatalf("Expected only version 11.1.0 but got %v", resp)
+		}
+
+		args, err := ioutil.ReadFile(argsLog)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.Contains(string(args), "fetch") {
+			t.Fatalf("Expected git fetch to be run, but got %q", args)
+		}
+
+		for _, secret := range []string{"hunter2", "YWRtaW46aHVudGVyMg==", "s3cr3t"} {
+			if strings.Contains(string(args), secret) {
+				t.Fatalf("Expected %q not to be passed on the command line, but got %q", secret, args)
+			}
+		}
+
+		keys, err := ioutil.ReadFile(keysLog)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the headers are only sent to the repository
+		if len(strings.Fields(string(keys))) == 0 {
+			t.Fatalf("Expected the headers to be configured for git")
+		}
+
+		for _, key := range strings.Fields(string(keys)) {
+			expected := "=http." + server.URL + "/charts.git.extraHeader"
+			if !strings.HasSuffix(key, expected) {
+				t.Fatalf("Expected every configured key to end with %q, but got %q", expected, key)
+			}
+		}
+	})
+}
+
+// gitRepository is a local bare repository, along with a working copy to
+// commit to it from
+type gitRepository struct {
+	t    *testing.T
+	dir  string
+	bare string
+	work string
+}
+
+func newGitRepository(t *testing.T) *gitRepository {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir, err := ioutil.TempDir(os.TempDir(), "helm-git-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := &gitRepository{t: t, dir: dir, bare: filepath.Join(dir, "charts.git"), work: filepath.Join(dir, "work")}
+	repo.git(dir, "init", "-q", "--bare", repo.bare)
+	repo.git(dir, "init", "-q", repo.work)
+	repo.git(repo.work, "checkout", "-q", "-b", "main")
+
+	return repo
+}
+
+func (g *gitRepository) commit(files map[string]string) {
+	for name, contents := range files {
+		target := filepath.Join(g.work, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			g.t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(target, []byte(contents), 0644); err != nil {
+			g.t.Fatal(err)
+		}
+	}
+
+	g.git(g.work, "add", "-A")
+	g.git(g.work, "-c", "user.name=Test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "Update charts")
+	g.git(g.work, "push", "-q", g.bare, "main")
+}
+
+func (g *gitRepository) git(dir string, args ...string) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		g.t.Fatalf("git %s failed: %v: %s", args[0], err, output)
+	}
+}
+
+func TestFileRepository(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "helm-file-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err = os.MkdirAll(filepath.Join(dir, "stable"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(dir, "stable", "index.yaml"), []byte(chartYAML), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := runCheck(context.Background(), &fakeClient{}, check.Request{
+		Source: resource.Source{
+			RepositoryURL: "file://" + filepath.ToSlash(filepath.Join(dir, "stable")),
+			ChartName:     "concourse",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	if len(resp) != 1 || resp[0].Version != "11.1.0" {
+		t.Fatalf("Expected only version 11.1.0 but got %v", resp)
+	}
+
+	_, err = runCheck(context.Background(), &fakeClient{}, check.Request{
+		Source: resource.Source{
+			RepositoryURL: "file://" + filepath.ToSlash(filepath.Join(dir, "missing")),
+			ChartName:     "concourse",
+		},
+	})
+	if !os.IsNotExist(err) {
+		t.Fatalf("Expected a missing index to fail, but got %v", err)
+	}
+}
+
+func TestMultipleCharts(t *testing.T) {
+	index := umbrellaYAML
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(index))
+	}))
+	defer server.Close()
+
+	source := resource.Source{
+		RepositoryURL: server.URL,
+		Charts:        []string{"concourse", "postgresql"},
+	}
+
+	resp, err := runCheck(context.Background(), server.Client(), check.Request{Source: source})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	if len(resp) != 1 || resp[0].Version != "concourse=11.1.0,postgresql=8.6.4" || resp[0].Hash == "" {
+		t.Fatalf("Expected one composite version but got %v", resp)
+	}
+	cursor := resp[0]
+
+	t.Run("It reports the cursor when nothing has changed", func(t *testing.T) {
+		resp, err := runCheck(context.Background(), server.Client(), check.Request{Source: source, Version: &cursor})
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		if len(resp) != 1 || resp[0] != cursor {
+			t.Fatalf("Expected only %v but got %v", cursor, resp)
+		}
+	})
+
+	t.Run("It reports a new version when any chart changes", func(t *testing.T) {
+		index = strings.Replace(umbrellaYAML, "  postgresql:\n", "  postgresql:\n  - name: postgresql\n    version: 8.6.5\n    digest: 99b1\n", 1)
+		defer func() { index = umbrellaYAML }()
+
+		resp, err := runCheck(context.Background(), server.Client(), check.Request{Source: source, Version: &cursor})
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		if len(resp) != 2 || resp[0] != cursor || resp[1].Version != "concourse=11.1.0,postgresql=8.6.5" {
+			t.Fatalf("Expected the cursor and the new version but got %v", resp)
+		}
+	})
+
+	t.Run("It reports a new version when a chart is republished", func(t *testing.T) {
+		index = strings.Replace(umbrellaYAML, "digest: 86f5", "digest: 1c2e", 1)
+		defer func() { index = umbrellaYAML }()
+
+		resp, err := runCheck(context.Background(), server.Client(), check.Request{Source: source, Version: &cursor})
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		if len(resp) != 2 || resp[1].Version != cursor.Version || resp[1].Hash == cursor.Hash {
+			t.Fatalf("Expected a version with a new hash but got %v", resp)
+		}
+	})
+
+	t.Run("It skips a cursor that is no longer in the repository", func(t *testing.T) {
+		gone := resource.Version{Version: "concourse=11.1.0,postgresql=7.0.0", Hash: "abc"}
+		resp, err := runCheck(context.Background(), server.Client(), check.Request{Source: source, Version: &gone})
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		if len(resp) != 1 || resp[0] != cursor {
+			t.Fatalf("Expected only %v but got %v", cursor, resp)
+		}
+	})
+
+	t.Run("It fails if a chart is missing", func(t *testing.T) {
+		missing := source
+		missing.Charts = []string{"concourse", "mysql"}
+		if _, err := runCheck(context.Background(), server.Client(), check.Request{Source: missing}); err == nil {
+			t.Fatal("Expected a missing chart to fail")
+		}
+	})
+}
+
+const umbrellaYAML = `apiVersion: v1
+entries:
+  concourse:
+  - name: concourse
+    version: 11.1.0
+    digest: 86f5f3bd5380eaf6331b6413b5628ceed7116f316ab83c302191c319d168a2d7
+  - name: concourse
+    version: 11.0.0
+    digest: 35d8b2d3f5c1e0a9b7c6d5e4f3a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f5a4
+  postgresql:
+  - name: postgresql
+    version: 8.6.4
+    digest: 0f3b4c5d6e7f8a9b0c1d2e3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d0e1f2a3b
+  mysql-operator:
+  - name: mysql-operator
+    version: 1.0.0
+`
+
+func TestChartPattern(t *testing.T) {
+	index := teamYAML
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(index))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		pattern  string
+		cursor   *resource.Version
+		expected check.Response
+	}{
+		{
+			name:     "It reports the latest version of any matching chart",
+			pattern:  "team-*",
+			expected: check.Response{{Chart: "team-b", Version: "2.1.0", Seen: "team-a=1.1.0,team-b=2.1.0"}},
+		},
+		{
+			name:    "It reports new versions of every matching chart in the order they were created",
+			pattern: "team-*",
+			cursor:  &resource.Version{Chart: "team-a", Version: "1.0.0", Seen: "team-a=1.0.0"},
+			expected: check.Response{
+				{Chart: "team-a", Version: "1.0.0", Seen: "team-a=1.0.0"},
+				{Chart: "team-b", Version: "2.0.0", Seen: "team-a=1.0.0,team-b=2.0.0"},
+				{Chart: "team-a", Version: "1.1.0", Seen: "team-a=1.1.0,team-b=2.0.0"},
+				{Chart: "team-b", Version: "2.1.0", Seen: "team-a=1.1.0,team-b=2.1.0"},
+			},
+		},
+		{
+			name:    "It matches regular expressions",
+			pattern: "/^(team-a|other)$/",
+			cursor:  &resource.Version{Chart: "team-a", Version: "1.1.0", Seen: "team-a=1.1.0"},
+			expected: check.Response{
+				{Chart: "team-a", Version: "1.1.0", Seen: "team-a=1.1.0"},
+				{Chart: "other", Version: "0.1.0", Seen: "other=0.1.0,team-a=1.1.0"},
+			},
+		},
+		{
+			name:     "It reports only the cursor when nothing is new",
+			pattern:  "team-*",
+			cursor:   &resource.Version{Chart: "team-b", Version: "2.1.0", Seen: "team-a=1.1.0,team-b=2.1.0"},
+			expected: check.Response{{Chart: "team-b", Version: "2.1.0", Seen: "team-a=1.1.0,team-b=2.1.0"}},
+		},
+		{
+			name:     "It starts over from the latest versions if the cursor doesn't record what was seen",
+			pattern:  "team-a",
+			cursor:   &resource.Version{Chart: "team-b", Version: "2.0.0"},
+			expected: check.Response{{Chart: "team-a", Version: "1.1.0", Seen: "team-a=1.1.0"}},
+		},
+		{
+			name:     "It reports nothing if no charts match",
+			pattern:  "platform-*",
+			expected: check.Response{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := runCheck(context.Background(), server.Client(), check.Request{
+				Source: resource.Source{
+					RepositoryURL: server.URL,
+					ChartPattern:  test.pattern,
+				},
+				Version: test.cursor,
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+
+			if len(resp) != len(test.expected) {
+				t.Fatalf("Expected %v but got %v", test.expected, resp)
+			}
+
+			for i := range resp {
+				if resp[i] != test.expected[i] {
+					t.Fatalf("Expected %v but got %v", test.expected, resp)
+				}
+			}
+		})
+	}
+
+	t.Run("It reports new charts", func(t *testing.T) {
+		index = teamYAML + `  team-c:
+  - name: team-c
+    version: 0.0.1
+    created: "2020-06-06T00:00:00Z"
+`
+		defer func() { index = teamYAML }()
+
+		resp, err := runCheck(context.Background(), server.Client(), check.Request{
+			Source: resource.Source{
+				RepositoryURL: server.URL,
+				ChartPattern:  "team-*",
+			},
+			Version: &resource.Version{Chart: "team-b", Version: "2.1.0", Seen: "team-a=1.1.0,team-b=2.1.0"},
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		if len(resp) != 2 || resp[1] != (resource.Version{Chart: "team-c", Version: "0.0.1", Seen: "team-a=1.1.0,team-b=2.1.0,team-c=0.0.1"}) {
+			t.Fatalf("Expected the new chart to be reported but got %v", resp)
+		}
+	})
+
+	t.Run("It reports versions created before the cursor", func(t *testing.T) {
+		// a backported release of team-a, created shortly before team-b 2.0.0
+		index = strings.Replace(teamYAML, `  team-b:
+`, `  - name: team-a
+    version: 1.0.1
+    created: "2020-06-01T23:30:00Z"
+  team-b:
+`, 1)
+		defer func() { index = teamYAML }()
+
+		resp, err := runCheck(context.Background(), server.Client(), check.Request{
+			Source: resource.Source{
+				RepositoryURL: server.URL,
+				ChartPattern:  "team-*",
+			},
+			Version: &resource.Version{Chart: "team-b", Version: "2.0.0", Seen: "team-a=1.0.0,team-b=2.0.0"},
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		expected := check.Response{
+			{Chart: "team-b", Version: "2.0.0", Seen: "team-a=1.0.0,team-b=2.0.0"},
+			{Chart: "team-a", Version: "1.0.1", Seen: "team-a=1.0.1,team-b=2.0.0"},
+			{Chart: "team-a", Version: "1.1.0", Seen: "team-a=1.1.0,team-b=2.0.0"},
+			{Chart: "team-b", Version: "2.1.0", Seen: "team-a=1.1.0,team-b=2.1.0"},
+		}
+		if len(resp) != len(expected) {
+			t.Fatalf("Expected %v but got %v", expected, resp)
+		}
+
+		for i := range resp {
+			if resp[i] != expected[i] {
+				t.Fatalf("Expected %v but got %v", expected, resp)
+			}
+		}
+	})
+
+	t.Run("It rejects invalid patterns", func(t *testing.T) {
+		for _, pattern := range []string{"team-[", "/team-(/"} {
+			_, err := runCheck(context.Background(), server.Client(), check.Request{
+				Source: resource.Source{
+					RepositoryURL: server.URL,
+					ChartPattern:  pattern,
+				},
+			})
+			if err == nil || !strings.Contains(err.Error(), "Invalid chart_pattern") {
+				t.Fatalf("Expected pattern %q to be rejected, but got %v", pattern, err)
+			}
+		}
+	})
+}
+
+const teamYAML = `apiVersion: v1
+entries:
+  other:
+  - name: other
+    version: 0.1.0
+    created: "2020-06-05T00:00:00Z"
+  team-a:
+  - name: team-a
+    version: 1.1.0
+    created: "2020-06-03T00:00:00Z"
+  - name: team-a
+    version: 1.0.0
+    created: "2020-06-01T00:00:00Z"
+  team-b:
+  - name: team-b
+    version: 2.1.0
+    created: "2020-06-04T00:00:00Z"
+  - name: team-b
+    version: 2.0.0
+    created: "2020-06-02T00:00:00Z"
+`
+
+func TestInitialVersions(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		version  *resource.Version
+		expected []string
+	}{
+		{
+			name:     "It reports the last N versions",
+			source:   `{"initial_versions": 3}`,
+			expected: []string{"11.0.0", "11.0.1", "11.1.0"},
+		},
+		{
+			name:     "It reports every version",
+			source:   `{"initial_versions": "all"}`,
+			expected: []string{"8.2.6", "8.2.7", "8.2.10", "8.2.12", "8.2.13", "8.4.1", "9.0.0", "9.1.0", "9.1.1", "9.1.3", "10.0.0", "10.0.1", "10.0.2", "10.0.3", "10.0.4", "10.0.5", "10.0.6", "10.0.7", "10.1.0", "10.2.0", "10.2.1", "10.2.2", "10.2.3", "10.3.0", "11.0.0", "11.0.1", "11.1.0"},
+		},
+		{
+			name:     "It respects the version constraint",
+			source:   `{"initial_versions": 3, "version_constraint": "~10.2"}`,
+			expected: []string{"10.2.1", "10.2.2", "10.2.3"},
+		},
+		{
+			name:     "It reports every version if there are fewer than N",
+			source:   `{"initial_versions": 10, "version_constraint": "9.x"}`,
+			expected: []string{"9.0.0", "9.1.0", "9.1.1", "9.1.3"},
+		},
+		{
+			name:     "It reports only the latest version by default",
+			source:   `{"initial_versions": 0}`,
+			expected: []string{"11.1.0"},
+		},
+		{
+			name:     "It is ignored when there is a cursor",
+			source:   `{"initial_versions": "all"}`,
+			version:  &resource.Version{Version: "11.0.1"},
+			expected: []string{"11.0.1", "11.1.0"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := check.Request{Version: test.version}
+			if err := json.Unmarshal([]byte(test.source), &req.Source); err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			req.Source.RepositoryURL = "https://example.com/"
+			req.Source.ChartName = "concourse"
+
+			resp, err := runCheck(context.Background(), &fakeClient{}, req)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+
+			versions := []string{}
+			for _, v := range resp {
+				versions = append(versions, v.Version)
+			}
+
+			if strings.Join(versions, ",") != strings.Join(test.expected, ",") {
+				t.Fatalf("Expected versions %v but got %v", test.expected, versions)
+			}
+		})
+	}
+
+	t.Run("It applies to chart patterns", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte(teamYAML))
+		}))
+		defer server.Close()
+
+		resp, err := runCheck(context.Background(), server.Client(), check.Request{
+			Source: resource.Source{
+				RepositoryURL:   server.URL,
+				ChartPattern:    "team-*",
+				InitialVersions: 2,
+			},
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		if len(resp) != 2 ||
+			resp[0] != (resource.Version{Chart: "team-a", Version: "1.1.0", Seen: "team-a=1.1.0,team-b=2.0.0"}) ||
+			resp[1] != (resource.Version{Chart: "team-b", Version: "2.1.0", Seen: "team-a=1.1.0,team-b=2.1.0"}) {
+			t.Fatalf("Expected the last 2 versions but got %v", resp)
+		}
+	})
+
+	t.Run("It rejects invalid values", func(t *testing.T) {
+		for _, value := range []string{`"some"`, `""`, `-1`, `1.5`, `true`} {
+			source := resource.Source{}
+			if err := json.Unmarshal([]byte(`{"initial_versions": `+value+`}`), &source); err == nil || !strings.Contains(err.Error(), "Invalid initial_versions") {
+				t.Fatalf("Expected %s to be rejected, but got %v", value, err)
+			}
+		}
+
+		// null leaves the default alone, as for any other field
+		source := resource.Source{}
+		if err := json.Unmarshal([]byte(`{"initial_versions": null}`), &source); err != nil || source.InitialVersions != 0 {
+			t.Fatalf("Expected null to leave initial_versions unset, but got %d, %v", source.InitialVersions, err)
+		}
+	})
+}
+
+func TestTokenAuthentication(t *testing.T) {
+	client := &headerClient{
+		client: &fakeClient{},
+		name:   "Authorization",
+		value:  "Bearer t0k3n",
+	}
+
+	checkReq := check.Request{
+		Source: resource.Source{
+			RepositoryURL: "https://example.com/",
+			ChartName:     "concourse",
+			Token:         "t0k3n",
+		},
+	}
+
+	t.Run("It sends a bearer token", func(t *testing.T) {
+		if _, err := runCheck(context.Background(), client, checkReq); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	})
+
+	t.Run("It sends custom headers", func(t *testing.T) {
+		gitlab := &headerClient{
+			client: &fakeClient{},
+			name:   "PRIVATE-TOKEN",
+			value:  "glpat-123",
+		}
+
+		req := checkReq
+		req.Source.Token = ""
+		req.Source.Headers = map[string]string{"PRIVATE-TOKEN": "glpat-123"}
+
+		if _, err := runCheck(context.Background(), gitlab, req); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	})
+
+	t.Run("It doesn't work with a bad token", func(t *testing.T) {
+		req := checkReq
+		req.Source.Token = "garbage"
+
+		if _, err := runCheck(context.Background(), client, req); err == nil {
+			t.Fatalf("An error should have occurred but none did")
+		}
+	})
+}
+
+type headerClient struct {
+	client *fakeClient
+	name   string
+	value  string
+}
+
+func (h *headerClient) Do(req *http.Request) (*http.Response, error) {
+	if req.Header.Get(h.name) != h.value {
+		w := httptest.NewRecorder()
+		w.WriteHeader(http.StatusUnauthorized)
+		return w.Result(), nil
+	}
+
+	return h.client.Do(req)
+}
+
 type fakeClient struct{}
 
 func (f *fakeClient) Do(req *http.Request) (*http.Response, error) {
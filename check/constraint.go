@@ -0,0 +1,147 @@
+package check
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/blang/semver/v4"
+)
+
+// parseConstraint parses a version constraint into a semver.Range. On top of
+// the range syntax blang/semver understands, it accepts the tilde (~) and caret
+// (^) operators, commas between ANDed terms as helm writes them, and partial
+// versions such as ">=10.3, <12", translating them into their equivalent
+// ranges.
+func parseConstraint(constraint string) (semver.Range, error) {
+	fields := strings.Fields(strings.Replace(constraint, ",", " ", -1))
+
+	terms := []string{}
+	for i := 0; i < len(fields); i++ {
+		term := fields[i]
+		if term == "||" {
+			terms = append(terms, term)
+			continue
+		}
+
+		// allow a space between the operator and the version, e.g. ">= 10.3"
+		if strings.Trim(term, "<>=!~^") == "" && i+1 < len(fields) {
+			i++
+			term += fields[i]
+		}
+
+		expanded, err := expandTerm(term)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid version_constraint %q: %v", constraint, err)
+		}
+		terms = append(terms, expanded...)
+	}
+
+	r, err := semver.ParseRange(strings.Join(terms, " "))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid version_constraint %q: %v", constraint, err)
+	}
+
+	return r, nil
+}
+
+func expandTerm(term string) ([]string, error) {
+	version := strings.TrimLeft(term, "<>=!~^")
+	op := term[:len(term)-len(version)]
+	version = strings.TrimPrefix(version, "v")
+
+	parts := strings.SplitN(version, ".", 3)
+	nums := []uint64{}
+	for _, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 64)
+		if err != nil {
+			break
+		}
+		nums = append(nums, n)
+	}
+
+	switch op {
+	case "~":
+		if len(nums) == 0 {
+			return nil, fmt.Errorf("%q is not a valid version", version)
+		}
+		lower := pad(version, nums)
+		if len(nums) == 1 {
+			return []string{">=" + lower, fmt.Sprintf("<%d.0.0", nums[0]+1)}, nil
+		}
+		return []string{">=" + lower, fmt.Sprintf("<%d.%d.0", nums[0], nums[1]+1)}, nil
+	case "^":
+		if len(nums) == 0 {
+			return nil, fmt.Errorf("%q is not a valid version", version)
+		}
+		lower := pad(version, nums)
+		for len(nums) < 3 {
+			nums = append(nums, 0)
+		}
+		switch {
+		case nums[0] > 0 || len(parts) == 1:
+			return []string{">=" + lower, fmt.Sprintf("<%d.0.0", nums[0]+1)}, nil
+		case nums[1] > 0 || len(parts) == 2:
+			return []string{">=" + lower, fmt.Sprintf("<0.%d.0", nums[1]+1)}, nil
+		default:
+			return []string{">=" + lower, fmt.Sprintf("<0.0.%d", nums[2]+1)}, nil
+		}
+	case "", "=", "==":
+		if version == "*" || version == "x" || version == "X" {
+			return []string{">=0.0.0"}, nil
+		}
+
+		// a partial version on its own matches anything in that line
+		if len(parts) < 3 && len(nums) == len(parts) {
+			return []string{">=" + pad(version, nums), "<" + nextLine(nums)}, nil
+		}
+	case ">", "<=":
+		// a partial bound covers the whole line it names, so >10.3 starts
+		// at 10.4.0 and <=10.3 includes every 10.3.x
+		if len(parts) < 3 && len(nums) == len(parts) {
+			if op == ">" {
+				return []string{">=" + nextLine(nums)}, nil
+			}
+			return []string{"<" + nextLine(nums)}, nil
+		}
+	case "!=":
+		// excluding a whole line would need an OR inside an AND, which the
+		// range syntax can't express
+		if len(parts) < 3 {
+			return nil, fmt.Errorf("%q needs a full version, e.g. %s.0", term, strings.Join(parts, "."))
+		}
+	default:
+		if len(parts) < 3 && len(nums) == len(parts) {
+			return []string{op + pad(version, nums)}, nil
+		}
+	}
+
+	return []string{op + version}, nil
+}
+
+// nextLine returns the first version after the line named by the one or two
+// elements of a partial version
+func nextLine(nums []uint64) string {
+	if len(nums) == 1 {
+		return fmt.Sprintf("%d.0.0", nums[0]+1)
+	}
+	return fmt.Sprintf("%d.%d.0", nums[0], nums[1]+1)
+}
+
+// pad fills in any missing minor or patch elements of a partial version with
+// zeros
+func pad(version string, nums []uint64) string {
+	if strings.Count(version, ".") >= 2 {
+		return version
+	}
+
+	padded := make([]string, 3)
+	for i := range padded {
+		padded[i] = "0"
+		if i < len(nums) {
+			padded[i] = strconv.FormatUint(nums[i], 10)
+		}
+	}
+
+	return strings.Join(padded, ".")
+}
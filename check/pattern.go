@@ -0,0 +1,174 @@
+package check
+
+import (
+	"sort"
+
+	resource "github.com/jghiloni/helm-resource"
+	"github.com/jghiloni/helm-resource/repository"
+)
+
+type chartInfo struct {
+	chart string
+	// index is the position of the version among the chart's versions, as
+	// ordered by discover
+	index int
+	info  resource.HelmChartInfo
+}
+
+// checkPattern reports the versions of every chart matching the chart pattern,
+// keyed by chart name. Each version records the newest version of every chart
+// seen as of that version, so that the next check reports any version of any
+// chart newer than the one already seen, regardless of when it was created.
+// New versions are reported in the order they were created.
+func checkPattern(repo resource.HelmChartRepository, req Request) (Response, error) {
+	match, err := repository.ChartPattern(req.Source.ChartPattern)
+	if err != nil {
+		return Response{}, err
+	}
+
+	names := []string{}
+	for name := range repo.Entries {
+		if match(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	charts := map[string][]resource.HelmChartInfo{}
+	timeline := []chartInfo{}
+	for _, name := range names {
+		infos, err := discover(repo.Entries[name], req.Source)
+		if err != nil {
+			return Response{}, err
+		}
+
+		if len(infos) == 0 {
+			continue
+		}
+
+		charts[name] = infos
+		for i, info := range infos {
+			timeline = append(timeline, chartInfo{chart: name, index: i, info: info})
+		}
+	}
+
+	if len(timeline) == 0 {
+		return Response{}, nil
+	}
+
+	sort.SliceStable(timeline, func(i, j int) bool {
+		return timeline[i].info.Created.Before(timeline[j].info.Created)
+	})
+
+	if req.Version == nil {
+		start := initialIndex(len(timeline), req.Source.InitialVersions)
+
+		// every version created before the reported ones counts as seen
+		seen := map[string]int{}
+		for _, v := range timeline[:start] {
+			if index, ok := seen[v.chart]; !ok || v.index > index {
+				seen[v.chart] = v.index
+			}
+		}
+
+		return seenVersions(charts, seen, timeline[start:]), nil
+	}
+
+	seen, ok := parseSeen(charts, *req.Version)
+	if !ok {
+		// the cursor doesn't say what was seen, so start over from the latest
+		// version of every chart
+		latest := map[string]int{}
+		for name, infos := range charts {
+			latest[name] = len(infos) - 1
+		}
+
+		return seenVersions(charts, latest, timeline[len(timeline)-1:]), nil
+	}
+
+	newVersions := []chartInfo{}
+	versions := Response{}
+	for _, v := range timeline {
+		if v.chart == req.Version.Chart && v.info.Version == req.Version.Version {
+			versions = Response{*req.Version}
+		}
+
+		if index, ok := seen[v.chart]; !ok || v.index > index {
+			newVersions = append(newVersions, v)
+		}
+	}
+
+	return append(versions, seenVersions(charts, seen, newVersions)...), nil
+}
+
+// parseSeen returns the index of the newest version seen of every chart
+// recorded by the cursor. Charts that aren't recorded are new, and a chart
+// whose recorded version is no longer listed is taken to be up to date.
+func parseSeen(charts map[string][]resource.HelmChartInfo, cursor resource.Version) (map[string]int, bool) {
+	if cursor.Seen == "" {
+		return nil, false
+	}
+
+	versions, err := resource.ParseCompositeVersion(cursor.Seen)
+	if err != nil {
+		return nil, false
+	}
+
+	seen := map[string]int{}
+	for _, v := range versions {
+		infos, ok := charts[v.Chart]
+		if !ok {
+			continue
+		}
+
+		seen[v.Chart] = len(infos) - 1
+		for i, info := range infos {
+			if info.Version == v.Version {
+				seen[v.Chart] = i
+				break
+			}
+		}
+	}
+
+	return seen, true
+}
+
+// seenVersions returns the versions to report, each recording the newest
+// version of every chart seen once it is reported on top of those already
+// seen
+func seenVersions(charts map[string][]resource.HelmChartInfo, seen map[string]int, reported []chartInfo) Response {
+	current := map[string]int{}
+	for name, index := range seen {
+		current[name] = index
+	}
+
+	versions := Response{}
+	for _, v := range reported {
+		if index, ok := current[v.chart]; !ok || v.index > index {
+			current[v.chart] = v.index
+		}
+
+		versions = append(versions, resource.Version{
+			Chart:   v.chart,
+			Version: v.info.Version,
+			Seen:    seenVersion(charts, current),
+		})
+	}
+
+	return versions
+}
+
+func seenVersion(charts map[string][]resource.HelmChartInfo, seen map[string]int) string {
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	versions := make([]resource.ChartVersion, 0, len(names))
+	for _, name := range names {
+		versions = append(versions, resource.ChartVersion{Chart: name, Version: charts[name][seen[name]].Version})
+	}
+
+	return resource.CompositeVersion(versions)
+}
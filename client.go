@@ -2,16 +2,28 @@ package resource
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"net"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
+// maxRedirects matches the limit of the default net/http redirect policy
+const maxRedirects = 10
+
 type HTTPClient interface {
 	Do(*http.Request) (*http.Response, error)
 }
 
-func NewClient(skipTLSValidation bool) HTTPClient {
+func NewClient(source Source) (HTTPClient, error) {
+	tlsConfig, err := newTLSConfig(source)
+	if err != nil {
+		return nil, err
+	}
+
 	transport := &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
 		DialContext: (&net.Dialer{
@@ -24,12 +36,95 @@ func NewClient(skipTLSValidation bool) HTTPClient {
 		IdleConnTimeout:       90 * time.Second,
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: skipTLSValidation,
-		},
+		TLSClientConfig:       tlsConfig,
+	}
+
+	return newRetryClient(&http.Client{
+		Transport:     transport,
+		CheckRedirect: checkRedirect(source),
+	}, source)
+}
+
+// checkRedirect keeps the custom headers from following a redirect to another
+// origin, unless pass_credentials_all is set. net/http already drops the
+// Authorization header and cookies there, but copies every other header.
+func checkRedirect(source Source) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+
+		if source.PassCredentialsAll {
+			return nil
+		}
+
+		for _, prev := range via {
+			if !SameOrigin(req.URL, prev.URL) {
+				for name := range source.Headers {
+					req.Header.Del(name)
+				}
+				break
+			}
+		}
+
+		return nil
+	}
+}
+
+// SameOrigin reports whether two URLs share a scheme, host and port.
+func SameOrigin(a, b *url.URL) bool {
+	return strings.EqualFold(a.Scheme, b.Scheme) &&
+		strings.EqualFold(a.Hostname(), b.Hostname()) &&
+		port(a) == port(b)
+}
+
+func port(u *url.URL) string {
+	if p := u.Port(); p != "" {
+		return p
 	}
 
-	return &http.Client{
-		Transport: transport,
+	switch strings.ToLower(u.Scheme) {
+	case "http":
+		return "80"
+	case "https":
+		return "443"
 	}
+
+	return ""
+}
+
+func newTLSConfig(source Source) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: source.SkipTLSValidation,
+	}
+
+	if strings.TrimSpace(source.CACert) != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM([]byte(source.CACert)) {
+			return nil, fmt.Errorf("No valid PEM certificates found in ca_cert")
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	hasCert := strings.TrimSpace(source.ClientCert) != ""
+	hasKey := strings.TrimSpace(source.ClientKey) != ""
+	if hasCert != hasKey {
+		return nil, fmt.Errorf("client_cert and client_key must be set together")
+	}
+
+	if hasCert {
+		cert, err := tls.X509KeyPair([]byte(source.ClientCert), []byte(source.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid client_cert or client_key: %v", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
 }
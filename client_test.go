@@ -0,0 +1,236 @@
+package resource_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	resource "github.com/jghiloni/helm-resource"
+)
+
+func TestMutualTLS(t *testing.T) {
+	ca := newCertificate(t, nil, "Test CA")
+	serverCert := newCertificate(t, ca, "127.0.0.1")
+	clientCert := newCertificate(t, ca, "concourse")
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert.tlsCertificate(t)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(source resource.Source) error {
+		client, err := resource.NewClient(source)
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		return nil
+	}
+
+	t.Run("It connects with a custom CA and client certificate", func(t *testing.T) {
+		err := get(resource.Source{
+			CACert:     ca.certPEM,
+			ClientCert: clientCert.certPEM,
+			ClientKey:  clientCert.keyPEM,
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	})
+
+	t.Run("It doesn't trust the server without the CA", func(t *testing.T) {
+		err := get(resource.Source{
+			ClientCert: clientCert.certPEM,
+			ClientKey:  clientCert.keyPEM,
+		})
+		if err == nil {
+			t.Fatalf("An error should have occurred but none did")
+		}
+	})
+
+	t.Run("It doesn't connect without a client certificate", func(t *testing.T) {
+		err := get(resource.Source{CACert: ca.certPEM})
+		if err == nil {
+			t.Fatalf("An error should have occurred but none did")
+		}
+	})
+
+	t.Run("It rejects invalid PEM data", func(t *testing.T) {
+		for _, source := range []resource.Source{
+			{CACert: "garbage"},
+			{ClientCert: clientCert.certPEM},
+			{ClientCert: clientCert.certPEM, ClientKey: ca.keyPEM},
+		} {
+			if _, err := resource.NewClient(source); err == nil {
+				t.Fatalf("An error should have occurred but none did")
+			}
+		}
+	})
+}
+
+func TestRedirectHeaders(t *testing.T) {
+	var received http.Header
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		received = req.Header
+		w.Write([]byte("chart"))
+	}))
+	defer cdn.Close()
+
+	repo := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/charts/concourse-11.1.0.tgz":
+			http.Redirect(w, req, cdn.URL+"/blobs/concourse-11.1.0.tgz", http.StatusFound)
+		case "/moved/concourse-11.1.0.tgz":
+			http.Redirect(w, req, "/stored/concourse-11.1.0.tgz", http.StatusFound)
+		default:
+			received = req.Header
+			w.Write([]byte("chart"))
+		}
+	}))
+	defer repo.Close()
+
+	get := func(source resource.Source, path string) http.Header {
+		received = nil
+		source.Headers = map[string]string{"PRIVATE-TOKEN": "secret"}
+
+		client, err := resource.NewClient(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest(http.MethodGet, repo.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("PRIVATE-TOKEN", "secret")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		return received
+	}
+
+	t.Run("It drops custom headers when redirected to another origin", func(t *testing.T) {
+		if header := get(resource.Source{}, "/charts/concourse-11.1.0.tgz"); header.Get("PRIVATE-TOKEN") != "" {
+			t.Fatalf("Expected the PRIVATE-TOKEN header not to be sent to the redirected host")
+		}
+	})
+
+	t.Run("It keeps custom headers when redirected within the same origin", func(t *testing.T) {
+		if header := get(resource.Source{}, "/moved/concourse-11.1.0.tgz"); header.Get("PRIVATE-TOKEN") != "secret" {
+			t.Fatalf("Expected the PRIVATE-TOKEN header to be sent, but got %v", header)
+		}
+	})
+
+	t.Run("It keeps custom headers with pass_credentials_all", func(t *testing.T) {
+		header := get(resource.Source{PassCredentialsAll: true}, "/charts/concourse-11.1.0.tgz")
+		if header.Get("PRIVATE-TOKEN") != "secret" {
+			t.Fatalf("Expected the PRIVATE-TOKEN header to be sent, but got %v", header)
+		}
+	})
+}
+
+type certificate struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM string
+	keyPEM  string
+}
+
+func newCertificate(t *testing.T, parent *certificate, commonName string) *certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	if ip := net.ParseIP(commonName); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &certificate{
+		cert:    cert,
+		key:     key,
+		certPEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		keyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}
+}
+
+func (c *certificate) tlsCertificate(t *testing.T) tls.Certificate {
+	cert, err := tls.X509KeyPair([]byte(c.certPEM), []byte(c.keyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
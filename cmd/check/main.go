@@ -7,6 +7,7 @@ import (
 
 	resource "github.com/jghiloni/helm-resource"
 	"github.com/jghiloni/helm-resource/check"
+	"github.com/jghiloni/helm-resource/repository"
 )
 
 func main() {
@@ -17,9 +18,23 @@ func main() {
 		log.Fatal(err)
 	}
 
-	client := resource.NewClient(req.Source.SkipTLSValidation)
+	client, err := resource.NewClient(req.Source)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	backend, err := repository.NewBackend(client, req.Source)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel, err := resource.NewContext(req.Source)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer cancel()
 
-	resp, err := check.RunCommand(client, req)
+	resp, err := check.RunCommand(ctx, backend, req)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -2,35 +2,41 @@ package main
 
 import (
 	"encoding/json"
-	"io"
-	"io/ioutil"
 	"log"
 	"os"
 
 	resource "github.com/jghiloni/helm-resource"
 	"github.com/jghiloni/helm-resource/in"
+	"github.com/jghiloni/helm-resource/repository"
 )
 
 func main() {
 	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime | log.LUTC)
 	var req in.Request
 
-	var file *os.File
-	var err error
-	if file, err = ioutil.TempFile(os.TempDir(), "in-"); err != nil {
+	decoder := json.NewDecoder(os.Stdin)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
 		log.Fatal(err)
 	}
 
-	decoder := json.NewDecoder(io.TeeReader(os.Stdin, file))
-	decoder.DisallowUnknownFields()
-	if err := decoder.Decode(&req); err != nil {
+	client, err := resource.NewClient(req.Source)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	backend, err := repository.NewBackend(client, req.Source)
+	if err != nil {
 		log.Fatal(err)
 	}
-	file.Close()
 
-	client := resource.NewClient(req.Source.SkipTLSValidation)
+	ctx, cancel, err := resource.NewContext(req.Source)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer cancel()
 
-	resp, err := in.RunCommand(os.Args[1], client, req)
+	resp, err := in.RunCommand(ctx, os.Args[1], backend, req)
 	if err != nil {
 		log.Fatal(err)
 	}
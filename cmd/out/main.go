@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+
+	resource "github.com/jghiloni/helm-resource"
+	"github.com/jghiloni/helm-resource/out"
+)
+
+func main() {
+	log.SetFlags(log.Lshortfile | log.Ldate | log.Ltime | log.LUTC)
+	var req out.Request
+
+	decoder := json.NewDecoder(os.Stdin)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		log.Fatal(err)
+	}
+
+	client, err := resource.NewClient(req.Source)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel, err := resource.NewContext(req.Source)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer cancel()
+
+	resp, err := out.RunCommand(ctx, os.Args[1], client, req)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := json.NewEncoder(os.Stdout).Encode(resp); err != nil {
+		log.Fatal(err)
+	}
+}
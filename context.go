@@ -0,0 +1,42 @@
+package resource
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const defaultTimeout = 5 * time.Minute
+
+// NewContext returns a context that is cancelled when Concourse interrupts
+// the step with SIGTERM or SIGINT, or once the source's timeout has elapsed.
+func NewContext(source Source) (context.Context, context.CancelFunc, error) {
+	timeout := defaultTimeout
+	if source.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(source.Timeout); err != nil {
+			return nil, nil, fmt.Errorf("Invalid timeout %q: %v", source.Timeout, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}, nil
+}
@@ -0,0 +1,56 @@
+package resource_test
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	resource "github.com/jghiloni/helm-resource"
+)
+
+func TestNewContext(t *testing.T) {
+	t.Run("It times out", func(t *testing.T) {
+		ctx, cancel, err := resource.NewContext(resource.Source{Timeout: "10ms"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cancel()
+
+		select {
+		case <-ctx.Done():
+			if ctx.Err() != context.DeadlineExceeded {
+				t.Fatalf("Expected the deadline to be exceeded, but got %v", ctx.Err())
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("The context was not cancelled after the timeout")
+		}
+	})
+
+	t.Run("It is cancelled by SIGTERM", func(t *testing.T) {
+		ctx, cancel, err := resource.NewContext(resource.Source{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cancel()
+
+		if err = syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			if ctx.Err() != context.Canceled {
+				t.Fatalf("Expected the context to be cancelled, but got %v", ctx.Err())
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("The context was not cancelled by SIGTERM")
+		}
+	})
+
+	t.Run("It rejects an invalid timeout", func(t *testing.T) {
+		if _, _, err := resource.NewContext(resource.Source{Timeout: "forever"}); err == nil {
+			t.Fatalf("An error should have occurred but none did")
+		}
+	})
+}
@@ -4,5 +4,6 @@ go 1.14
 
 require (
 	github.com/blang/semver/v4 v4.0.0
+	golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e
 	gopkg.in/yaml.v2 v2.3.0
 )
@@ -0,0 +1,66 @@
+package in
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	resource "github.com/jghiloni/helm-resource"
+	"github.com/jghiloni/helm-resource/repository"
+)
+
+// getCharts gets every chart version in a composite version into a
+// subdirectory named after the chart
+func getCharts(ctx context.Context, baseDir string, backend repository.Backend, repo resource.HelmChartRepository, req Request) (Response, error) {
+	versions, err := resource.ParseCompositeVersion(req.Version.Version)
+	if err != nil {
+		return Response{}, err
+	}
+
+	metadata := []resource.MetadataField{
+		{Name: "repository", Value: req.Source.RepositoryURL},
+	}
+
+	for _, v := range versions {
+		if v.Chart != filepath.Base(v.Chart) || v.Chart == "." || v.Chart == ".." {
+			return Response{}, fmt.Errorf("Invalid chart name %q", v.Chart)
+		}
+
+		chartDir := filepath.Join(baseDir, v.Chart)
+		if err = os.MkdirAll(chartDir, 0755); err != nil {
+			return Response{}, err
+		}
+
+		chartReq := req
+		chartReq.Source.ChartName = v.Chart
+		chartReq.Source.Charts = nil
+		chartReq.Version = resource.Version{Version: v.Version}
+
+		if _, err = getChart(ctx, chartDir, backend, repo, chartReq); err != nil {
+			return Response{}, err
+		}
+
+		metadata = append(metadata, resource.MetadataField{Name: v.Chart, Value: v.Version})
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(baseDir, "version"), []byte(req.Version.Version), 0644); err != nil {
+		return Response{}, err
+	}
+
+	contents, err := json.Marshal(metadata)
+	if err != nil {
+		return Response{}, err
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(baseDir, "metadata.json"), contents, 0644); err != nil {
+		return Response{}, err
+	}
+
+	return Response{
+		Version:  req.Version,
+		Metadata: metadata,
+	}, nil
+}
@@ -0,0 +1,138 @@
+package in
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	resource "github.com/jghiloni/helm-resource"
+)
+
+// chartDigest returns the digest a downloaded file is expected to have. Only
+// chart archives are covered by the index digest, so any other file is not
+// verified.
+func chartDigest(target string, chartInfo resource.HelmChartInfo) string {
+	if isChartArchive(target) {
+		return chartInfo.Digest
+	}
+
+	return ""
+}
+
+func isChartArchive(name string) bool {
+	return strings.HasSuffix(name, ".tgz") || strings.HasSuffix(name, ".tar.gz")
+}
+
+// fileName returns the name to download a file listed in a chart's urls to.
+// URLs that reference the chart by tag instead of naming a file, such as those
+// of OCI registries, or that don't name a file at all, are named like a
+// packaged chart.
+func fileName(chartURL string, chartName string, version string) string {
+	name := urlBase(chartURL)
+	if strings.Contains(name, ":") || name == "." || name == ".." || name == "/" {
+		return fmt.Sprintf("%s-%s.tgz", chartName, version)
+	}
+
+	return name
+}
+
+// download writes the file opened by open to target, verifying its digest
+func download(ctx context.Context, open func(context.Context, string) (io.ReadCloser, error), fileURL string, target string, digest string) error {
+	body, err := open(ctx, fileURL)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return writeVerified(body, target, fileURL, digest)
+}
+
+// writeVerified streams r to target while hashing it, removing the file if
+// anything goes wrong or the SHA-256 digest does not match. An empty digest
+// skips verification.
+func writeVerified(r io.Reader, target string, source string, digest string) (err error) {
+	if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	targetFile, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if closeErr := targetFile.Close(); err == nil {
+			err = closeErr
+		}
+
+		if err != nil {
+			os.Remove(target)
+		}
+	}()
+
+	hash := sha256.New()
+	if _, err = io.Copy(io.MultiWriter(targetFile, hash), r); err != nil {
+		return err
+	}
+
+	expected := strings.ToLower(strings.TrimPrefix(digest, "sha256:"))
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if expected != "" && expected != actual {
+		return fmt.Errorf("Digest mismatch for %s: expected %s, got %s", source, expected, actual)
+	}
+
+	return nil
+}
+
+// filterURLs returns the URLs whose file names, as given by name, match at
+// least one of the globs. Every glob must match at least one URL. If there are
+// no globs, all of the URLs are returned.
+func filterURLs(urls []string, globs []string, name func(string) string) ([]string, error) {
+	if len(globs) == 0 {
+		return urls, nil
+	}
+
+	matched := make([]bool, len(urls))
+	for _, glob := range globs {
+		found := false
+		for i, fileURL := range urls {
+			ok, err := path.Match(glob, name(fileURL))
+			if err != nil {
+				return nil, fmt.Errorf("Invalid glob %q: %v", glob, err)
+			}
+
+			if ok {
+				matched[i] = true
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("Glob %q did not match any files", glob)
+		}
+	}
+
+	filtered := []string{}
+	for i, fileURL := range urls {
+		if matched[i] {
+			filtered = append(filtered, fileURL)
+		}
+	}
+
+	return filtered, nil
+}
+
+func urlBase(fileURL string) string {
+	if u, err := url.Parse(fileURL); err == nil {
+		return path.Base(u.Path)
+	}
+
+	return path.Base(fileURL)
+}
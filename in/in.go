@@ -1,23 +1,26 @@
 package in
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
-	"net/url"
+	"log"
 	"os"
-	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	resource "github.com/jghiloni/helm-resource"
 	"github.com/jghiloni/helm-resource/repository"
+	"golang.org/x/crypto/openpgp"
 )
 
 type Params struct {
 	SkipDownload bool     `json:"skip_download"`
 	Globs        []string `json:"globs"`
+	Unpack       bool     `json:"unpack"`
 }
 
 type Request struct {
@@ -31,15 +34,68 @@ type Response struct {
 	Metadata []resource.MetadataField `json:"metadata"`
 }
 
-func RunCommand(baseDir string, client resource.HTTPClient, req Request) (Response, error) {
-	repo, err := repository.Fetch(client, req.Source)
+func RunCommand(ctx context.Context, baseDir string, backend repository.Backend, req Request) (Response, error) {
+	repo, err := backend.Index(ctx)
 	if err != nil {
 		return Response{}, err
 	}
 
+	selectors := 0
+	for _, set := range []bool{req.Source.ChartName != "", len(req.Source.Charts) > 0, req.Source.ChartPattern != ""} {
+		if set {
+			selectors++
+		}
+	}
+	if selectors > 1 {
+		return Response{}, fmt.Errorf("Only one of chart, charts and chart_pattern may be set")
+	}
+
+	if len(req.Source.Charts) > 0 {
+		return getCharts(ctx, baseDir, backend, repo, req)
+	}
+
+	if req.Source.ChartPattern != "" {
+		if req.Version.Chart == "" {
+			return Response{}, fmt.Errorf("Version %q does not name a chart", req.Version.Version)
+		}
+
+		// the chart is unpacked into a directory with its name, which must stay
+		// inside baseDir
+		if v := req.Version; v.Chart != filepath.Base(v.Chart) || v.Chart == "." || v.Chart == ".." {
+			return Response{}, fmt.Errorf("Invalid chart name %q", v.Chart)
+		}
+
+		match, err := repository.ChartPattern(req.Source.ChartPattern)
+		if err != nil {
+			return Response{}, err
+		}
+
+		if !match(req.Version.Chart) {
+			return Response{}, fmt.Errorf("Chart %q does not match chart_pattern %q", req.Version.Chart, req.Source.ChartPattern)
+		}
+
+		req.Source.ChartName = req.Version.Chart
+	}
+
+	metadata, err := getChart(ctx, baseDir, backend, repo, req)
+	if err != nil {
+		return Response{}, err
+	}
+
+	response := Response{
+		Version:  req.Version,
+		Metadata: metadata,
+	}
+
+	return response, nil
+}
+
+// getChart gets the chart version requested into baseDir, returning its
+// metadata
+func getChart(ctx context.Context, baseDir string, backend repository.Backend, repo resource.HelmChartRepository, req Request) ([]resource.MetadataField, error) {
 	chartVersions, ok := repo.Entries[req.Source.ChartName]
 	if !ok {
-		return Response{}, fmt.Errorf("No chart %q found", req.Source.ChartName)
+		return nil, fmt.Errorf("No chart %q found", req.Source.ChartName)
 	}
 
 	chartInfo := resource.HelmChartInfo{}
@@ -51,66 +107,107 @@ func RunCommand(baseDir string, client resource.HTTPClient, req Request) (Respon
 	}
 
 	if chartInfo.Version != req.Version.Version {
-		return Response{}, fmt.Errorf("No chart with version %q found", req.Version.Version)
+		return nil, fmt.Errorf("No chart with version %q found", req.Version.Version)
 	}
 
-	if !req.Params.SkipDownload {
-		for _, chartURL := range chartInfo.URLs {
+	if chartInfo.Deprecated {
+		log.Printf("WARNING: version %s of chart %q is deprecated", chartInfo.Version, req.Source.ChartName)
+	}
 
-			var u *url.URL
-			u, err = url.Parse(chartURL)
-			if err != nil {
-				return Response{}, err
-			}
+	var err error
+	var keyring openpgp.EntityList
+	if req.Source.Verify {
+		keyring, err = readKeyring(req.Source.Keyring)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-			if u.Scheme == "" {
-				u, err = url.Parse(req.Source.RepositoryURL)
-				if err != nil {
-					return Response{}, err
-				}
+	if req.Params.Unpack && req.Params.SkipDownload {
+		return nil, fmt.Errorf("The unpack param cannot be used with skip_download")
+	}
 
-				u.Path = path.Join(u.Path, chartURL)
-			}
+	chartInfo, storeMetadata, err := backend.Describe(ctx, chartInfo)
+	if err != nil {
+		return nil, err
+	}
 
-			target := filepath.Join(baseDir, filepath.Base(chartURL))
-			if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
-				return Response{}, err
-			}
+	var chartSigner signer
+	var chartArchive string
+	if !req.Params.SkipDownload {
+		// an OCI chart is a single artifact rather than a list of files, so
+		// there is nothing for the globs to select
+		globs := req.Params.Globs
+		if repository.IsOCI(req.Source.RepositoryURL) {
+			globs = nil
+		}
 
-			targetFile, err := os.Create(target)
-			if err != nil {
-				return Response{}, err
+		chartURLs, err := filterURLs(chartInfo.URLs, globs, func(chartURL string) string {
+			return fileName(chartURL, req.Source.ChartName, chartInfo.Version)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, chartURL := range chartURLs {
+			target := filepath.Join(baseDir, fileName(chartURL, req.Source.ChartName, chartInfo.Version))
+			if err = download(ctx, backend.Open, chartURL, target, chartDigest(target, chartInfo)); err != nil {
+				return nil, err
 			}
-			defer targetFile.Close()
 
-			httpReq, err := http.NewRequest(http.MethodGet, u.String(), nil)
-			if err != nil {
-				return Response{}, err
+			if chartArchive == "" && isChartArchive(target) {
+				chartArchive = target
 			}
 
-			httpResp, err := client.Do(httpReq)
-			if err != nil {
-				return Response{}, err
+			if keyring != nil && isChartArchive(target) {
+				if err = download(ctx, backend.Provenance, chartURL, target+".prov", ""); err != nil {
+					return nil, err
+				}
+
+				if chartSigner, err = verifyProvenance(keyring, target+".prov", target); err != nil {
+					return nil, err
+				}
 			}
-			defer httpResp.Body.Close()
+		}
+	}
+
+	if req.Params.Unpack {
+		if chartArchive == "" {
+			return nil, fmt.Errorf("No chart archive was downloaded to unpack")
+		}
+
+		chartDir := filepath.Join(baseDir, req.Source.ChartName)
+		if err = unpack(chartArchive, chartDir); err != nil {
+			return nil, err
+		}
 
-			_, err = io.Copy(targetFile, httpResp.Body)
-			if err != nil {
-				return Response{}, err
+		for _, name := range []string{"Chart.yaml", "values.yaml"} {
+			if err = copyChartFile(chartDir, baseDir, name); err != nil {
+				return nil, err
 			}
 		}
 	}
 
+	chartFile, err := os.Create(filepath.Join(baseDir, "chart.json"))
+	if err != nil {
+		return nil, err
+	}
+	defer chartFile.Close()
+
+	if err = json.NewEncoder(chartFile).Encode(chartInfo); err != nil {
+		return nil, err
+	}
+
 	versionFile, err := os.Create(filepath.Join(baseDir, "version"))
 	if err != nil {
-		return Response{}, err
+		return nil, err
 	}
 	defer versionFile.Close()
 	versionFile.WriteString(req.Version.Version)
 
 	metadataFile, err := os.Create(filepath.Join(baseDir, "metadata.json"))
 	if err != nil {
-		return Response{}, err
+		return nil, err
 	}
 	defer metadataFile.Close()
 
@@ -120,17 +217,69 @@ func RunCommand(baseDir string, client resource.HTTPClient, req Request) (Respon
 		{Name: "digest", Value: chartInfo.Digest},
 		{Name: "app_version", Value: chartInfo.AppVersion},
 		{Name: "created", Value: chartInfo.Created.Format(time.RFC3339)},
+		{Name: "deprecated", Value: strconv.FormatBool(chartInfo.Deprecated)},
+	}
+	metadata = append(metadata, chartMetadata(chartInfo)...)
+
+	metadata = append(metadata, storeMetadata...)
+
+	if chartSigner.Fingerprint != "" {
+		metadata = append(metadata,
+			resource.MetadataField{Name: "signed_by", Value: chartSigner.Identity},
+			resource.MetadataField{Name: "signing_key", Value: chartSigner.Fingerprint},
+		)
 	}
 
 	err = json.NewEncoder(metadataFile).Encode(metadata)
 	if err != nil {
-		return Response{}, err
+		return nil, err
 	}
 
-	response := Response{
-		Version:  req.Version,
-		Metadata: metadata,
+	return metadata, nil
+}
+
+// chartMetadata flattens the descriptive fields of the index entry into
+// metadata fields, leaving out any that are empty
+func chartMetadata(chartInfo resource.HelmChartInfo) []resource.MetadataField {
+	maintainers := []string{}
+	for _, m := range chartInfo.Maintainers {
+		if m.Email != "" {
+			maintainers = append(maintainers, fmt.Sprintf("%s <%s>", m.Name, m.Email))
+		} else {
+			maintainers = append(maintainers, m.Name)
+		}
 	}
 
-	return response, nil
+	dependencies := []string{}
+	for _, d := range chartInfo.Dependencies {
+		dependencies = append(dependencies, fmt.Sprintf("%s@%s", d.Name, d.Version))
+	}
+
+	fields := []resource.MetadataField{
+		{Name: "home", Value: chartInfo.Home},
+		{Name: "sources", Value: strings.Join(chartInfo.Sources, ", ")},
+		{Name: "maintainers", Value: strings.Join(maintainers, ", ")},
+		{Name: "keywords", Value: strings.Join(chartInfo.Keywords, ", ")},
+		{Name: "kube_version", Value: chartInfo.KubeVersion},
+		{Name: "dependencies", Value: strings.Join(dependencies, ", ")},
+	}
+
+	annotations := make([]string, 0, len(chartInfo.Annotations))
+	for key := range chartInfo.Annotations {
+		annotations = append(annotations, key)
+	}
+	sort.Strings(annotations)
+
+	for _, key := range annotations {
+		fields = append(fields, resource.MetadataField{Name: "annotations." + key, Value: chartInfo.Annotations[key]})
+	}
+
+	metadata := []resource.MetadataField{}
+	for _, field := range fields {
+		if field.Value != "" {
+			metadata = append(metadata, field)
+		}
+	}
+
+	return metadata
 }
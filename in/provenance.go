@@ -0,0 +1,134 @@
+package in
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+	"gopkg.in/yaml.v2"
+)
+
+const armorStart = "-----BEGIN "
+
+type signer struct {
+	Identity    string
+	Fingerprint string
+}
+
+func readKeyring(armored string) (openpgp.EntityList, error) {
+	if strings.TrimSpace(armored) == "" {
+		return nil, fmt.Errorf("A keyring is required to verify chart provenance")
+	}
+
+	// openpgp.ReadArmoredKeyRing only reads the first armored block, but keys
+	// are usually exported and pasted in one block at a time
+	keyring := openpgp.EntityList{}
+	for _, block := range strings.Split(armored, armorStart)[1:] {
+		entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armorStart + block))
+		if err != nil {
+			return nil, fmt.Errorf("Could not read keyring: %v", err)
+		}
+		keyring = append(keyring, entities...)
+	}
+
+	if len(keyring) == 0 {
+		return nil, fmt.Errorf("Could not read keyring: no keys found")
+	}
+
+	return keyring, nil
+}
+
+// verifyProvenance checks that provPath holds a provenance file clearsigned by
+// a key in the keyring, and that the SHA-256 it lists for the chart archive
+// matches the archive at chartPath.
+func verifyProvenance(keyring openpgp.EntityList, provPath string, chartPath string) (signer, error) {
+	prov, err := ioutil.ReadFile(provPath)
+	if err != nil {
+		return signer{}, err
+	}
+
+	block, _ := clearsign.Decode(prov)
+	if block == nil {
+		return signer{}, fmt.Errorf("%s is not a signed provenance file", filepath.Base(provPath))
+	}
+
+	entity, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(block.Bytes), block.ArmoredSignature.Body)
+	if err != nil {
+		return signer{}, fmt.Errorf("Could not verify signature of %s: %v", filepath.Base(provPath), err)
+	}
+
+	// the signed message is the chart's Chart.yaml followed by a YAML document
+	// with the hashes of the signed files, separated by a "..." line
+	parts := strings.SplitN(string(block.Plaintext), "\n...\n", 2)
+	if len(parts) != 2 {
+		return signer{}, fmt.Errorf("%s does not contain any file hashes", filepath.Base(provPath))
+	}
+
+	hashes := struct {
+		Files map[string]string `yaml:"files"`
+	}{}
+	if err = yaml.Unmarshal([]byte(parts[1]), &hashes); err != nil {
+		return signer{}, err
+	}
+
+	name := filepath.Base(chartPath)
+	expected, ok := hashes.Files[name]
+	if !ok {
+		return signer{}, fmt.Errorf("%s does not contain a hash for %s", filepath.Base(provPath), name)
+	}
+
+	actual, err := fileSHA256(chartPath)
+	if err != nil {
+		return signer{}, err
+	}
+
+	if strings.TrimPrefix(expected, "sha256:") != actual {
+		return signer{}, fmt.Errorf("Provenance hash mismatch for %s: expected %s, got %s", name, strings.TrimPrefix(expected, "sha256:"), actual)
+	}
+
+	return signer{
+		Identity:    identityName(entity),
+		Fingerprint: strings.ToUpper(hex.EncodeToString(entity.PrimaryKey.Fingerprint[:])),
+	}, nil
+}
+
+func identityName(entity *openpgp.Entity) string {
+	names := []string{}
+	for name, identity := range entity.Identities {
+		if identity.SelfSignature != nil && identity.SelfSignature.IsPrimaryId != nil && *identity.SelfSignature.IsPrimaryId {
+			return name
+		}
+		names = append(names, name)
+	}
+
+	if len(names) == 0 {
+		return ""
+	}
+
+	sort.Strings(names)
+	return names[0]
+}
+
+func fileSHA256(p string) (string, error) {
+	file, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,190 @@
+package in
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// unpack extracts a chart archive into dest, dropping the top level directory
+// every packaged chart is nested under. Entries that would be written outside
+// of dest, including symlinks pointing outside of it and paths that only
+// escape once earlier symlinks are followed, fail the extraction.
+func unpack(archivePath string, dest string) error {
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	gz, err := gzip.NewReader(archive)
+	if err != nil {
+		return fmt.Errorf("Could not unpack %s: %v", filepath.Base(archivePath), err)
+	}
+	defer gz.Close()
+
+	dest, err = filepath.Abs(dest)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+
+	// every check is made against real paths, so symlinks unpacked earlier
+	// can't be used to reach outside of dest
+	dest, err = filepath.EvalSymlinks(dest)
+	if err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Could not unpack %s: %v", filepath.Base(archivePath), err)
+		}
+
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("Refusing to unpack %q from %s: path escapes the destination", header.Name, filepath.Base(archivePath))
+		}
+
+		parts := strings.SplitN(name, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		target := filepath.Join(dest, filepath.FromSlash(parts[1]))
+		if !within(dest, target) {
+			return fmt.Errorf("Refusing to unpack %q from %s: path escapes the destination", header.Name, filepath.Base(archivePath))
+		}
+
+		if header.Typeflag != tar.TypeDir && header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA && header.Typeflag != tar.TypeSymlink {
+			continue
+		}
+
+		parent, err := realPath(filepath.Dir(target))
+		if err != nil {
+			return err
+		}
+		if !within(dest, parent) {
+			return fmt.Errorf("Refusing to unpack %q from %s: path escapes the destination through a symlink", header.Name, filepath.Base(archivePath))
+		}
+		target = filepath.Join(parent, filepath.Base(target))
+
+		if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 && header.Typeflag != tar.TypeDir {
+			return fmt.Errorf("Refusing to unpack %q from %s: path is an existing symlink", header.Name, filepath.Base(archivePath))
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err = os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err = unpackFile(tr, target, os.FileMode(header.Mode).Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			link := filepath.FromSlash(header.Linkname)
+			if filepath.IsAbs(link) {
+				return fmt.Errorf("Refusing to unpack %q from %s: symlink to %q escapes the destination", header.Name, filepath.Base(archivePath), header.Linkname)
+			}
+
+			resolved, err := realPath(filepath.Join(parent, link))
+			if err != nil {
+				return err
+			}
+			if !within(dest, resolved) {
+				return fmt.Errorf("Refusing to unpack %q from %s: symlink to %q escapes the destination", header.Name, filepath.Base(archivePath), header.Linkname)
+			}
+
+			if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+
+			if err = os.Symlink(link, target); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func unpackFile(r io.Reader, target string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode|0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, r)
+	return err
+}
+
+// realPath resolves the symlinks in the part of p that already exists,
+// appending whatever doesn't exist yet unchanged
+func realPath(p string) (string, error) {
+	missing := ""
+	for {
+		real, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			return filepath.Join(real, missing), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+
+		parent := filepath.Dir(p)
+		if parent == p {
+			return filepath.Join(p, missing), nil
+		}
+
+		missing = filepath.Join(filepath.Base(p), missing)
+		p = parent
+	}
+}
+
+func within(dir string, target string) bool {
+	rel, err := filepath.Rel(dir, target)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// copyChartFile copies a file from the unpacked chart to the top of the output
+// directory, skipping files the chart doesn't have
+func copyChartFile(chartDir string, baseDir string, name string) error {
+	src, err := os.Open(filepath.Join(chartDir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(filepath.Join(baseDir, name))
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, src)
+	return err
+}
@@ -0,0 +1,164 @@
+package out
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	resource "github.com/jghiloni/helm-resource"
+	"github.com/jghiloni/helm-resource/chart"
+	"github.com/jghiloni/helm-resource/repository"
+)
+
+type Params struct {
+	Repository  string `json:"repository"`
+	VersionFile string `json:"version_file"`
+}
+
+type Request struct {
+	Source resource.Source `json:"source"`
+	Params Params          `json:"params"`
+}
+
+type Response struct {
+	Version  resource.Version         `json:"version"`
+	Metadata []resource.MetadataField `json:"metadata"`
+}
+
+func RunCommand(ctx context.Context, baseDir string, client resource.HTTPClient, req Request) (Response, error) {
+	if strings.TrimSpace(req.Params.Repository) == "" {
+		return Response{}, fmt.Errorf("The repository param is required")
+	}
+
+	chartPath := filepath.Join(baseDir, req.Params.Repository)
+	c, err := chart.Load(chartPath)
+	if err != nil {
+		return Response{}, err
+	}
+
+	if req.Source.ChartName != "" && c.Name != req.Source.ChartName {
+		return Response{}, fmt.Errorf("Chart %q does not match configured chart %q", c.Name, req.Source.ChartName)
+	}
+
+	if req.Params.VersionFile != "" {
+		contents, err := ioutil.ReadFile(filepath.Join(baseDir, req.Params.VersionFile))
+		if err != nil {
+			return Response{}, err
+		}
+
+		if err = c.SetVersion(strings.TrimSpace(string(contents))); err != nil {
+			return Response{}, err
+		}
+	}
+
+	archive, err := c.Package()
+	if err != nil {
+		return Response{}, err
+	}
+
+	if err = push(ctx, client, req.Source, c, archive); err != nil {
+		return Response{}, err
+	}
+
+	metadata, err := readMetadata(filepath.Join(filepath.Dir(chartPath), "metadata.json"))
+	if err != nil {
+		return Response{}, err
+	}
+
+	if metadata == nil {
+		metadata = []resource.MetadataField{
+			{Name: "repository", Value: req.Source.RepositoryURL},
+			{Name: "chart", Value: c.Name},
+		}
+	}
+
+	return Response{
+		Version:  resource.Version{Version: c.Version},
+		Metadata: metadata,
+	}, nil
+}
+
+func push(ctx context.Context, client resource.HTTPClient, source resource.Source, c *chart.Chart, archive []byte) error {
+	u, err := url.ParseRequestURI(source.RepositoryURL)
+	if err != nil {
+		return err
+	}
+	u.Path = path.Join(u.Path, "api", "charts")
+
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	part, err := form.CreateFormFile("chart", fmt.Sprintf("%s-%s.tgz", c.Name, c.Version))
+	if err != nil {
+		return err
+	}
+
+	if _, err = part.Write(archive); err != nil {
+		return err
+	}
+
+	if err = form.Close(); err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), body)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", form.FormDataContentType())
+
+	repository.Authorize(httpReq, source)
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Received bad HTTP response pushing %s-%s: %q %s", c.Name, c.Version, resp.Status, responseError(resp.Body))
+	}
+
+	return nil
+}
+
+// responseError extracts the error message chartmuseum sends back in its
+// JSON response body, if there is one.
+func responseError(body io.Reader) string {
+	var payload struct {
+		Error string `json:"error"`
+	}
+
+	if err := json.NewDecoder(body).Decode(&payload); err != nil {
+		return ""
+	}
+
+	return payload.Error
+}
+
+func readMetadata(metadataPath string) ([]resource.MetadataField, error) {
+	file, err := os.Open(metadataPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	metadata := []resource.MetadataField{}
+	if err = json.NewDecoder(file).Decode(&metadata); err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
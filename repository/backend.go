@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	resource "github.com/jghiloni/helm-resource"
+)
+
+// Backend provides access to a chart repository, wherever it is stored.
+type Backend interface {
+	// Index returns the repository index. Only the entries of the chart
+	// configured in the source are guaranteed to be present.
+	Index(ctx context.Context) (resource.HelmChartRepository, error)
+
+	// Describe completes the index entry of a chart version with any details
+	// that are only available from where the chart is stored, returning it
+	// along with any metadata about the stored chart.
+	Describe(ctx context.Context, info resource.HelmChartInfo) (resource.HelmChartInfo, []resource.MetadataField, error)
+
+	// Open opens a file listed in a chart's urls, resolving relative URLs
+	// against the repository URL. The caller must close the returned reader.
+	Open(ctx context.Context, chartURL string) (io.ReadCloser, error)
+
+	// Provenance opens the provenance file of the chart archive listed at
+	// chartURL. The caller must close the returned reader.
+	Provenance(ctx context.Context, chartURL string) (io.ReadCloser, error)
+}
+
+// NewBackendFunc creates a backend for the repository configured in a source.
+type NewBackendFunc func(client resource.HTTPClient, source resource.Source) (Backend, error)
+
+var backends = map[string]NewBackendFunc{
+	"http":                    newHTTPBackend,
+	"https":                   newHTTPBackend,
+	S3Scheme:                  newS3Backend,
+	OCIScheme:                 newOCIBackend,
+	FileScheme:                newFileBackend,
+	GitSchemePrefix + "http":  newGitBackend,
+	GitSchemePrefix + "https": newGitBackend,
+	GitSchemePrefix + "ssh":   newGitBackend,
+	GitSchemePrefix + "file":  newGitBackend,
+}
+
+// RegisterBackend makes a backend available for repository URLs with the
+// given scheme, replacing any backend already registered for it.
+func RegisterBackend(scheme string, newBackend NewBackendFunc) {
+	backends[strings.ToLower(scheme)] = newBackend
+}
+
+// NewBackend returns the backend for the scheme of the repository URL.
+func NewBackend(client resource.HTTPClient, source resource.Source) (Backend, error) {
+	u, err := url.Parse(source.RepositoryURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = ChartPattern(source.ChartPattern); err != nil {
+		return nil, err
+	}
+
+	newBackend, ok := backends[strings.ToLower(u.Scheme)]
+	if !ok {
+		return nil, fmt.Errorf("Unsupported repository URL %q", source.RepositoryURL)
+	}
+
+	return newBackend(client, source)
+}
+
+// openProvenance opens the provenance file published alongside a chart
+// archive, as helm does for repositories with an index.
+func openProvenance(ctx context.Context, backend Backend, source resource.Source, chartURL string) (io.ReadCloser, error) {
+	u, err := ResolveChartURL(source, chartURL)
+	if err != nil {
+		return nil, err
+	}
+
+	prov := *u
+	prov.Path += ".prov"
+	prov.RawPath = ""
+
+	return backend.Open(ctx, prov.String())
+}
@@ -0,0 +1,79 @@
+package repository_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	resource "github.com/jghiloni/helm-resource"
+	"github.com/jghiloni/helm-resource/repository"
+)
+
+func TestNewBackend(t *testing.T) {
+	for _, repositoryURL := range []string{
+		"http://example.com/charts",
+		"HTTPS://example.com/charts",
+		"s3://charts/stable",
+		"oci://registry.example.com/charts",
+		"file:///mnt/charts",
+		"git+https://github.com/example/charts@stable?ref=main",
+		"git+ssh://git@github.com/example/charts",
+		"git+file:///srv/git/charts.git",
+	} {
+		if _, err := repository.NewBackend(&indexClient{}, resource.Source{RepositoryURL: repositoryURL, ChartName: "concourse"}); err != nil {
+			t.Fatalf("Unexpected error creating a backend for %q: %v", repositoryURL, err)
+		}
+	}
+
+	_, err := repository.NewBackend(&indexClient{}, resource.Source{RepositoryURL: "ftp://example.com/charts"})
+	if err == nil || !strings.Contains(err.Error(), "Unsupported repository URL") {
+		t.Fatalf("Expected an unsupported scheme to fail, but got %v", err)
+	}
+
+	t.Run("It uses registered backends", func(t *testing.T) {
+		repository.RegisterBackend("memory", func(client resource.HTTPClient, source resource.Source) (repository.Backend, error) {
+			return memoryBackend{"concourse-11.1.0.tgz": "12345"}, nil
+		})
+
+		backend, err := repository.NewBackend(&indexClient{}, resource.Source{RepositoryURL: "memory://charts"})
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		file, err := backend.Open(context.Background(), "concourse-11.1.0.tgz")
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		defer file.Close()
+
+		contents, err := ioutil.ReadAll(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(contents) != "12345" {
+			t.Fatalf("Expected contents %q but got %q", "12345", contents)
+		}
+	})
+}
+
+type memoryBackend map[string]string
+
+func (m memoryBackend) Index(ctx context.Context) (resource.HelmChartRepository, error) {
+	return resource.HelmChartRepository{Entries: map[string][]resource.HelmChartInfo{}}, nil
+}
+
+func (m memoryBackend) Open(ctx context.Context, chartURL string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader([]byte(m[chartURL]))), nil
+}
+
+func (m memoryBackend) Describe(ctx context.Context, info resource.HelmChartInfo) (resource.HelmChartInfo, []resource.MetadataField, error) {
+	return info, nil, nil
+}
+
+func (m memoryBackend) Provenance(ctx context.Context, chartURL string) (io.ReadCloser, error) {
+	return m.Open(ctx, chartURL+".prov")
+}
@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	resource "github.com/jghiloni/helm-resource"
+)
+
+// indexCache keeps the last index downloaded from each repository on disk,
+// along with the validators needed to make a conditional request for it.
+type indexCache struct {
+	dir string
+}
+
+type cacheEntry struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	ContentType  string `json:"content_type,omitempty"`
+}
+
+func newIndexCache(source resource.Source) *indexCache {
+	dir := source.CacheDir
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "helm-chart-resource")
+	}
+
+	return &indexCache{dir: dir}
+}
+
+func (c *indexCache) key(indexURL string) string {
+	sum := sha256.Sum256([]byte(indexURL))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *indexCache) bodyPath(indexURL string) string {
+	return filepath.Join(c.dir, c.key(indexURL)+".index")
+}
+
+func (c *indexCache) entryPath(indexURL string) string {
+	return filepath.Join(c.dir, c.key(indexURL)+".json")
+}
+
+// lookup returns the cached entry for the index, if there is one with a body
+// that can be revalidated
+func (c *indexCache) lookup(indexURL string) (cacheEntry, bool) {
+	contents, err := ioutil.ReadFile(c.entryPath(indexURL))
+	if err != nil {
+		return cacheEntry{}, false
+	}
+
+	entry := cacheEntry{}
+	if err = json.Unmarshal(contents, &entry); err != nil || entry.URL != indexURL {
+		return cacheEntry{}, false
+	}
+
+	if _, err = os.Stat(c.bodyPath(indexURL)); err != nil {
+		return cacheEntry{}, false
+	}
+
+	return entry, true
+}
+
+func (c *indexCache) open(indexURL string) (*os.File, error) {
+	return os.Open(c.bodyPath(indexURL))
+}
+
+// conditional makes a request conditional on the cached index having
+// changed
+func (c *indexCache) conditional(header http.Header, entry cacheEntry) {
+	if entry.ETag != "" {
+		header.Set("If-None-Match", entry.ETag)
+	}
+
+	if entry.LastModified != "" {
+		header.Set("If-Modified-Since", entry.LastModified)
+	}
+}
+
+// create returns a writer for the body of a response that can be cached, or
+// nil if the response has no validators. The body is only stored once commit
+// is called.
+func (c *indexCache) create(indexURL string, resp *http.Response) *cacheWriter {
+	entry := cacheEntry{
+		URL:          indexURL,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		ContentType:  resp.Header.Get("Content-Type"),
+	}
+
+	if entry.ETag == "" && entry.LastModified == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		log.Printf("Not caching index: %v", err)
+		return nil
+	}
+
+	file, err := ioutil.TempFile(c.dir, "index-")
+	if err != nil {
+		log.Printf("Not caching index: %v", err)
+		return nil
+	}
+
+	return &cacheWriter{cache: c, entry: entry, file: file}
+}
+
+// cacheWriter never fails a write, so that a problem with the cache never
+// fails reading the index itself. Any error is reported when committing.
+type cacheWriter struct {
+	cache *indexCache
+	entry cacheEntry
+	file  *os.File
+	err   error
+}
+
+func (w *cacheWriter) Write(p []byte) (int, error) {
+	if w.err == nil {
+		_, w.err = w.file.Write(p)
+	}
+
+	return len(p), nil
+}
+
+func (w *cacheWriter) commit() {
+	if err := w.store(); err != nil {
+		log.Printf("Not caching index: %v", err)
+		os.Remove(w.file.Name())
+	}
+}
+
+func (w *cacheWriter) discard() {
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
+
+func (w *cacheWriter) store() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	if w.err != nil {
+		return w.err
+	}
+
+	contents, err := json.Marshal(w.entry)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Rename(w.file.Name(), w.cache.bodyPath(w.entry.URL)); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(w.cache.entryPath(w.entry.URL), contents, 0600)
+}
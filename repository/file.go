@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	resource "github.com/jghiloni/helm-resource"
+)
+
+const FileScheme = "file"
+
+// fileBackend reads a repository from a local directory. Files listed with
+// URLs that aren't local are downloaded as from any other repository.
+type fileBackend struct {
+	source resource.Source
+	remote Backend
+}
+
+func newFileBackend(client resource.HTTPClient, source resource.Source) (Backend, error) {
+	remote, err := newHTTPBackend(client, source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fileBackend{source: source, remote: remote}, nil
+}
+
+func (b *fileBackend) Index(ctx context.Context) (resource.HelmChartRepository, error) {
+	u, err := IndexURL(b.source)
+	if err != nil {
+		return resource.HelmChartRepository{}, err
+	}
+
+	index, err := openFile(u)
+	if err != nil {
+		return resource.HelmChartRepository{}, err
+	}
+	defer index.Close()
+
+	return readIndex(index, u.Path, "", wantChart(b.source))
+}
+
+func (b *fileBackend) Open(ctx context.Context, chartURL string) (io.ReadCloser, error) {
+	u, err := ResolveChartURL(b.source, chartURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.EqualFold(u.Scheme, FileScheme) {
+		return b.remote.Open(ctx, u.String())
+	}
+
+	return openFile(u)
+}
+
+func (b *fileBackend) Describe(ctx context.Context, info resource.HelmChartInfo) (resource.HelmChartInfo, []resource.MetadataField, error) {
+	return info, nil, nil
+}
+
+func (b *fileBackend) Provenance(ctx context.Context, chartURL string) (io.ReadCloser, error) {
+	return openProvenance(ctx, b, b.source, chartURL)
+}
+
+// openFile opens a local file. Only files on this host can be read, so URLs
+// naming any host other than localhost are rejected.
+func openFile(u *url.URL) (io.ReadCloser, error) {
+	if u.Host != "" && u.Host != "localhost" {
+		return nil, fmt.Errorf("%q is not a local file URL", u.String())
+	}
+
+	return os.Open(filepath.FromSlash(u.Path))
+}
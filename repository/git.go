@@ -0,0 +1,457 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	resource "github.com/jghiloni/helm-resource"
+	"github.com/jghiloni/helm-resource/chart"
+	"gopkg.in/yaml.v2"
+)
+
+const GitSchemePrefix = "git+"
+
+// checkouts records the checkouts already brought up to date by this process,
+// so that downloading a chart doesn't fetch the repository again
+var checkouts = struct {
+	sync.Mutex
+	synced map[string]bool
+}{synced: map[string]bool{}}
+
+func IsGit(repositoryURL string) bool {
+	return strings.HasPrefix(strings.ToLower(repositoryURL), GitSchemePrefix)
+}
+
+// gitLocation is a path within a git repository, addressed the same way as the
+// helm-git plugin: git+https://host/org/repo@path/in/repo?ref=main
+type gitLocation struct {
+	remote string
+	ref    string
+	path   string
+}
+
+func parseGitURL(u *url.URL) (gitLocation, error) {
+	if !IsGit(u.String()) {
+		return gitLocation{}, fmt.Errorf("%q is not a valid git URL", u.String())
+	}
+
+	repoPath, filePath := u.Path, ""
+	if i := strings.Index(u.Path, "@"); i >= 0 {
+		repoPath, filePath = u.Path[:i], u.Path[i+1:]
+	}
+
+	remote := url.URL{
+		Scheme: strings.TrimPrefix(strings.ToLower(u.Scheme), GitSchemePrefix),
+		User:   u.User,
+		Host:   u.Host,
+		Path:   repoPath,
+	}
+
+	ref := u.Query().Get("ref")
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	// git would take a ref starting with a dash for an option
+	if strings.HasPrefix(ref, "-") {
+		return gitLocation{}, fmt.Errorf("Invalid git ref %q", ref)
+	}
+
+	return gitLocation{
+		remote: remote.String(),
+		ref:    ref,
+		path:   path.Clean("/" + filePath),
+	}, nil
+}
+
+// repositoryRemote returns the remote of the repository named by the source.
+func repositoryRemote(source resource.Source) (string, error) {
+	u, err := url.Parse(source.RepositoryURL)
+	if err != nil {
+		return "", err
+	}
+
+	location, err := parseGitURL(u)
+	if err != nil {
+		return "", err
+	}
+
+	return location.remote, nil
+}
+
+// gitBaseURL returns the repository URL to resolve chart URLs against. A
+// repository URL without a path in the repository gets an empty one, so that
+// resolved URLs stay inside the repository.
+func gitBaseURL(repositoryURL string) string {
+	u, err := url.Parse(repositoryURL)
+	if err != nil || strings.Contains(u.Path, "@") {
+		return repositoryURL
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/") + "@"
+	return u.String()
+}
+
+// gitBackend reads a repository from a shallow checkout of a git repository.
+// Files listed with URLs outside of the repository are downloaded as from any
+// other repository.
+type gitBackend struct {
+	source resource.Source
+	remote Backend
+}
+
+func newGitBackend(client resource.HTTPClient, source resource.Source) (Backend, error) {
+	remote, err := newHTTPBackend(client, source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gitBackend{source: source, remote: remote}, nil
+}
+
+func (b *gitBackend) Index(ctx context.Context) (resource.HelmChartRepository, error) {
+	u, err := url.Parse(b.source.RepositoryURL)
+	if err != nil {
+		return resource.HelmChartRepository{}, err
+	}
+
+	location, err := parseGitURL(u)
+	if err != nil {
+		return resource.HelmChartRepository{}, err
+	}
+
+	dir, err := checkout(ctx, b.source, location)
+	if err != nil {
+		return resource.HelmChartRepository{}, err
+	}
+
+	chartsDir := filepath.Join(dir, filepath.FromSlash(location.path))
+
+	indexPath := b.source.IndexPath
+	if indexPath == "" {
+		indexPath = defaultIndexPath
+	}
+
+	index, err := os.Open(filepath.Join(chartsDir, filepath.FromSlash(indexPath)))
+	if err == nil {
+		defer index.Close()
+		return readIndex(index, indexPath, "", wantChart(b.source))
+	}
+
+	if !os.IsNotExist(err) {
+		return resource.HelmChartRepository{}, err
+	}
+
+	created, err := git(ctx, nil, dir, "log", "-1", "--format=%cI")
+	if err != nil {
+		return resource.HelmChartRepository{}, err
+	}
+
+	commitTime, err := time.Parse(time.RFC3339, created)
+	if err != nil {
+		return resource.HelmChartRepository{}, err
+	}
+
+	return scanCharts(chartsDir, commitTime, wantChart(b.source))
+}
+
+// scanCharts synthesizes an index from the Chart.yaml files under dir. Each
+// version is listed with the URL of an archive next to its Chart.yaml, which
+// is packaged when it is opened.
+func scanCharts(dir string, created time.Time, want func(string) bool) (resource.HelmChartRepository, error) {
+	repo := resource.HelmChartRepository{Entries: map[string][]resource.HelmChartInfo{}}
+
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		if info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+
+		contents, err := ioutil.ReadFile(filepath.Join(p, "Chart.yaml"))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		chartInfo := resource.HelmChartInfo{}
+		if err = yaml.Unmarshal(contents, &chartInfo); err != nil {
+			return fmt.Errorf("Invalid Chart.yaml in %q: %v", p, err)
+		}
+
+		// the charts a chart depends on are packaged with it, not listed
+		if chartInfo.Name == "" || chartInfo.Version == "" || !want(chartInfo.Name) {
+			return filepath.SkipDir
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		chartInfo.Created = created
+		chartInfo.URLs = []string{path.Join(filepath.ToSlash(rel), fmt.Sprintf("%s-%s.tgz", chartInfo.Name, chartInfo.Version))}
+		repo.Entries[chartInfo.Name] = append(repo.Entries[chartInfo.Name], chartInfo)
+
+		return filepath.SkipDir
+	})
+
+	if err != nil {
+		return resource.HelmChartRepository{}, err
+	}
+
+	return repo, nil
+}
+
+// Open opens a file in the git repository. An archive that isn't checked in is
+// packaged from the chart directory it was listed for.
+func (b *gitBackend) Open(ctx context.Context, chartURL string) (io.ReadCloser, error) {
+	u, err := ResolveChartURL(b.source, chartURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if !IsGit(u.String()) {
+		return b.remote.Open(ctx, u.String())
+	}
+
+	location, err := parseGitURL(u)
+	if err != nil {
+		return nil, err
+	}
+
+	// the index may only point into the repository it was read from, since
+	// any other remote is fetched with the repository's credentials
+	remote, err := repositoryRemote(b.source)
+	if err != nil {
+		return nil, err
+	}
+
+	if location.remote != remote {
+		return nil, fmt.Errorf("Refusing to open %q listed by %s", chartURL, b.source.RepositoryURL)
+	}
+
+	dir, err := checkout(ctx, b.source, location)
+	if err != nil {
+		return nil, err
+	}
+
+	filePath := filepath.Join(dir, filepath.FromSlash(location.path))
+	if file, err := os.Open(filePath); err == nil {
+		return file, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	chartDir := filepath.Dir(filePath)
+	if _, err = os.Stat(filepath.Join(chartDir, "Chart.yaml")); err == nil {
+		c, err := chart.Load(chartDir)
+		if err != nil {
+			return nil, err
+		}
+
+		if path.Base(location.path) == fmt.Sprintf("%s-%s.tgz", c.Name, c.Version) {
+			archive, err := c.Package()
+			if err != nil {
+				return nil, err
+			}
+
+			return ioutil.NopCloser(bytes.NewReader(archive)), nil
+		}
+	}
+
+	return nil, fmt.Errorf("No file %q found in %s at %s", location.path, location.remote, location.ref)
+}
+
+// Describe has nothing to add to the index entry, whether it was read from the
+// repository or synthesized from its Chart.yaml files.
+func (b *gitBackend) Describe(ctx context.Context, info resource.HelmChartInfo) (resource.HelmChartInfo, []resource.MetadataField, error) {
+	return info, nil, nil
+}
+
+func (b *gitBackend) Provenance(ctx context.Context, chartURL string) (io.ReadCloser, error) {
+	return openProvenance(ctx, b, b.source, chartURL)
+}
+
+// checkout brings a shallow checkout of the ref up to date, returning its
+// directory. Checkouts are kept in the cache directory between runs.
+func checkout(ctx context.Context, source resource.Source, location gitLocation) (string, error) {
+	sum := sha256.Sum256([]byte(location.remote + "#" + location.ref))
+	dir := filepath.Join(newIndexCache(source).dir, "git-"+hex.EncodeToString(sum[:]))
+
+	checkouts.Lock()
+	defer checkouts.Unlock()
+
+	if checkouts.synced[dir] {
+		return dir, nil
+	}
+
+	env, cleanup, err := gitConfig(source, location.remote)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err != nil {
+		if err = os.MkdirAll(dir, 0700); err != nil {
+			return "", err
+		}
+
+		if _, err = git(ctx, env, dir, "init", "-q"); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err := git(ctx, nil, dir, "check-ref-format", "--allow-onelevel", location.ref); err != nil {
+		return "", fmt.Errorf("Invalid git ref %q", location.ref)
+	}
+
+	steps := [][]string{
+		{"fetch", "-q", "--depth", "1", "--", location.remote, location.ref},
+		{"checkout", "-q", "--force", "FETCH_HEAD"},
+		{"clean", "-q", "-f", "-d", "-x"},
+	}
+	for _, args := range steps {
+		if _, err := git(ctx, env, dir, args...); err != nil {
+			return "", err
+		}
+	}
+
+	checkouts.synced[dir] = true
+	return dir, nil
+}
+
+// gitConfig returns the environment that configures git to make requests over
+// HTTP with the same credentials, headers and TLS settings as any other
+// repository request. It is passed through the environment rather than on the
+// command line, where any process could read the credentials. The headers are
+// only sent to the remote, and credentials only if it shares the origin of the
+// repository. The returned function removes the files the certificates are
+// written to for git.
+func gitConfig(source resource.Source, remote string) ([]string, func(), error) {
+	env := []string{}
+	if source.SkipTLSValidation {
+		env = append(env, "GIT_SSL_NO_VERIFY=true")
+	}
+
+	cleanup := func() {}
+	certDir := ""
+	for _, cert := range [][2]string{
+		{"GIT_SSL_CAINFO", source.CACert},
+		{"GIT_SSL_CERT", source.ClientCert},
+		{"GIT_SSL_KEY", source.ClientKey},
+	} {
+		if strings.TrimSpace(cert[1]) == "" {
+			continue
+		}
+
+		if certDir == "" {
+			var err error
+			if certDir, err = ioutil.TempDir("", "helm-git-"); err != nil {
+				return nil, nil, err
+			}
+			dir := certDir
+			cleanup = func() { os.RemoveAll(dir) }
+		}
+
+		file := filepath.Join(certDir, strings.ToLower(cert[0])+".pem")
+		if err := ioutil.WriteFile(file, []byte(cert[1]), 0600); err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		env = append(env, cert[0]+"="+file)
+	}
+
+	remoteURL, err := url.Parse(remote)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	// ssh and local remotes don't make HTTP requests
+	if !isHTTP(remoteURL) {
+		return env, cleanup, nil
+	}
+
+	repoRemote, err := repositoryRemote(source)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	repoURL, err := url.Parse(repoRemote)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodGet, remoteURL.String(), nil)
+	if err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+
+	if source.PassCredentialsAll || resource.SameOrigin(remoteURL, repoURL) {
+		Authorize(httpReq, source)
+	}
+
+	headers := []string{}
+	for name, values := range httpReq.Header {
+		for _, value := range values {
+			headers = append(headers, fmt.Sprintf("%s: %s", name, value))
+		}
+	}
+
+	// git matches the user of a scoped URL against the one it connects as,
+	// so scope the headers to the remote without it
+	remoteURL.User = nil
+	key := fmt.Sprintf("http.%s.extraHeader", remoteURL.String())
+
+	env = append(env, fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(headers)))
+	for i, header := range headers {
+		env = append(env,
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, key),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, header))
+	}
+
+	return env, cleanup, nil
+}
+
+// git runs a git command in dir with the extra environment, returning its
+// output.
+func git(ctx context.Context, env []string, dir string, args ...string) (string, error) {
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(append(os.Environ(), "GIT_TERMINAL_PROMPT=0"), env...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s failed: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
@@ -0,0 +1,181 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	resource "github.com/jghiloni/helm-resource"
+)
+
+const defaultIndexPath = "index.yaml"
+
+// httpBackend reads a repository served over HTTP.
+type httpBackend struct {
+	client resource.HTTPClient
+	source resource.Source
+}
+
+func newHTTPBackend(client resource.HTTPClient, source resource.Source) (Backend, error) {
+	return &httpBackend{client: client, source: source}, nil
+}
+
+func (b *httpBackend) Index(ctx context.Context) (resource.HelmChartRepository, error) {
+	u, err := IndexURL(b.source)
+	if err != nil {
+		return resource.HelmChartRepository{}, err
+	}
+
+	return fetchIndex(b.source, u, func(header http.Header) (*http.Response, error) {
+		return get(ctx, b.client, b.source, u, header, Authorize)
+	})
+}
+
+// Open downloads a file over HTTP. Files listed with any other scheme are
+// never opened, so that an index can't point at local files or have requests
+// signed for another storage type.
+func (b *httpBackend) Open(ctx context.Context, chartURL string) (io.ReadCloser, error) {
+	u, err := ResolveChartURL(b.source, chartURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isHTTP(u) {
+		return nil, fmt.Errorf("Refusing to open %s listed by %s", u.String(), b.source.RepositoryURL)
+	}
+
+	return download(ctx, b.client, b.source, u)
+}
+
+// Describe returns the index entry unchanged, since the index has every
+// detail.
+func (b *httpBackend) Describe(ctx context.Context, info resource.HelmChartInfo) (resource.HelmChartInfo, []resource.MetadataField, error) {
+	return info, nil, nil
+}
+
+func (b *httpBackend) Provenance(ctx context.Context, chartURL string) (io.ReadCloser, error) {
+	return openProvenance(ctx, b, b.source, chartURL)
+}
+
+// fetchIndex reads the index returned by fetch, which is passed the headers
+// for a conditional request when the index is cached.
+func fetchIndex(source resource.Source, u *url.URL, fetch func(http.Header) (*http.Response, error)) (resource.HelmChartRepository, error) {
+	indexPath := u.Path
+
+	cache := newIndexCache(source)
+	header := http.Header{}
+	entry, cached := cache.lookup(u.String())
+	if cached {
+		cache.conditional(header, entry)
+	}
+
+	resp, err := fetch(header)
+	if err != nil {
+		return resource.HelmChartRepository{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && cached {
+		body, err := cache.open(u.String())
+		if err != nil {
+			return resource.HelmChartRepository{}, err
+		}
+		defer body.Close()
+
+		return readIndex(body, indexPath, entry.ContentType, wantChart(source))
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resource.HelmChartRepository{}, fmt.Errorf("Received bad HTTP response: %q", resp.Status)
+	}
+
+	var body io.Reader = resp.Body
+	writer := cache.create(u.String(), resp)
+	if writer != nil {
+		body = io.TeeReader(resp.Body, writer)
+	}
+
+	repo, err := readIndex(body, indexPath, resp.Header.Get("Content-Type"), wantChart(source))
+	if writer != nil {
+		// the decoder may stop reading before the end of the body, but the
+		// cache needs all of it
+		if _, copyErr := io.Copy(ioutil.Discard, body); err != nil || copyErr != nil {
+			writer.discard()
+		} else {
+			writer.commit()
+		}
+	}
+
+	return repo, err
+}
+
+// download opens a file listed in the index over HTTP, authorizing the
+// request only if AuthorizeDownload allows it.
+func download(ctx context.Context, client resource.HTTPClient, source resource.Source, u *url.URL) (io.ReadCloser, error) {
+	resp, err := get(ctx, client, source, u, http.Header{}, AuthorizeDownload)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Received bad HTTP response downloading %s: %q", u.String(), resp.Status)
+	}
+
+	return resp.Body, nil
+}
+
+// get requests a file over HTTP, authorizing the request with authorize.
+func get(ctx context.Context, client resource.HTTPClient, source resource.Source, u *url.URL, header http.Header, authorize func(*http.Request, resource.Source)) (*http.Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range header {
+		httpReq.Header[name] = values
+	}
+
+	authorize(httpReq, source)
+
+	return client.Do(httpReq)
+}
+
+func isHTTP(u *url.URL) bool {
+	return strings.EqualFold(u.Scheme, "http") || strings.EqualFold(u.Scheme, "https")
+}
+
+// Authorize adds the credentials and headers configured in the source to the
+// request. A token takes precedence over basic auth, and custom headers take
+// precedence over both.
+func Authorize(httpReq *http.Request, source resource.Source) {
+	if source.Username != "" {
+		httpReq.SetBasicAuth(source.Username, source.Password)
+	}
+
+	if source.Token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+source.Token)
+	}
+
+	for name, value := range source.Headers {
+		httpReq.Header.Set(name, value)
+	}
+}
+
+// AuthorizeDownload authorizes a request for a file referenced by the index
+// only if it is served from the same origin as the repository, unless the
+// source opts in to passing credentials to every host.
+func AuthorizeDownload(httpReq *http.Request, source resource.Source) {
+	repoURL, err := url.Parse(source.RepositoryURL)
+	if err != nil {
+		return
+	}
+
+	if source.PassCredentialsAll || resource.SameOrigin(httpReq.URL, repoURL) {
+		Authorize(httpReq, source)
+	}
+}
@@ -0,0 +1,312 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"regexp"
+	"strings"
+
+	resource "github.com/jghiloni/helm-resource"
+	"gopkg.in/yaml.v2"
+)
+
+// readIndex reads a repository index in either YAML or JSON. The format is
+// taken from the content type of the response, falling back to the extension
+// of the index path and then to YAML. Gzip-compressed indexes, whether served
+// with Content-Encoding: gzip or as .gz files, are recognized by their magic
+// number, since the transport may already have decompressed them.
+func readIndex(r io.Reader, indexPath, contentType string, want func(string) bool) (resource.HelmChartRepository, error) {
+	reader := bufio.NewReaderSize(r, 64*1024)
+	if magic, err := reader.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return resource.HelmChartRepository{}, err
+		}
+		defer gz.Close()
+
+		reader = bufio.NewReaderSize(gz, 64*1024)
+	}
+
+	if isJSONIndex(indexPath, contentType) {
+		return decodeJSONIndex(reader, want)
+	}
+
+	return decodeIndex(reader, want)
+}
+
+func isJSONIndex(indexPath, contentType string) bool {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		switch {
+		case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+			return true
+		case strings.Contains(mediaType, "yaml"):
+			return false
+		}
+	}
+
+	return path.Ext(strings.TrimSuffix(indexPath, ".gz")) == ".json"
+}
+
+// decodeJSONIndex reads a JSON index token by token, decoding only the entries
+// of the charts selected by want and stopping once the entries are read.
+func decodeJSONIndex(r io.Reader, want func(string) bool) (resource.HelmChartRepository, error) {
+	repo := resource.HelmChartRepository{Entries: map[string][]resource.HelmChartInfo{}}
+
+	decoder := json.NewDecoder(r)
+	if err := expectDelim(decoder, '{'); err != nil {
+		return resource.HelmChartRepository{}, err
+	}
+
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return resource.HelmChartRepository{}, err
+		}
+
+		if key != "entries" {
+			if err = decoder.Decode(&json.RawMessage{}); err != nil {
+				return resource.HelmChartRepository{}, err
+			}
+			continue
+		}
+
+		token, err := decoder.Token()
+		if err != nil {
+			return resource.HelmChartRepository{}, err
+		}
+		if token == nil {
+			continue
+		}
+		if token != json.Delim('{') {
+			return resource.HelmChartRepository{}, fmt.Errorf("Invalid index: entries is not an object")
+		}
+
+		for decoder.More() {
+			name, err := decoder.Token()
+			if err != nil {
+				return resource.HelmChartRepository{}, err
+			}
+
+			if chart, ok := name.(string); ok && want(chart) {
+				versions := []resource.HelmChartInfo{}
+				if err = decoder.Decode(&versions); err != nil {
+					return resource.HelmChartRepository{}, err
+				}
+				repo.Entries[chart] = versions
+			} else if err = decoder.Decode(&json.RawMessage{}); err != nil {
+				return resource.HelmChartRepository{}, err
+			}
+		}
+
+		return repo, nil
+	}
+
+	return repo, nil
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	if token != delim {
+		return fmt.Errorf("Invalid index: expected %q but got %v", delim, token)
+	}
+
+	return nil
+}
+
+// decodeIndex reads a repository index, materializing only the entries of the
+// charts selected by want.
+//
+// Indexes generated by helm are block-style YAML documents, so rather than
+// building a node tree for the whole index, the entries mapping is scanned line
+// by line and only the lines belonging to the wanted charts are decoded. Each
+// chart's block runs from its key until the next line at the same or a lower
+// indentation that isn't a sequence item. Any index that isn't laid out that
+// way, such as one in flow style, is decoded in full and then filtered.
+func decodeIndex(r io.Reader, want func(string) bool) (resource.HelmChartRepository, error) {
+	reader := bufio.NewReaderSize(r, 64*1024)
+
+	// everything read before the entries mapping is kept in case the index has
+	// to be decoded in full
+	prefix := &bytes.Buffer{}
+	for {
+		line, err := reader.ReadString('\n')
+		if line == "" && err != nil {
+			if err == io.EOF {
+				return decodeFullIndex(prefix, want)
+			}
+			return resource.HelmChartRepository{}, err
+		}
+		prefix.WriteString(line)
+
+		content := strings.TrimRight(line, " \t\r\n")
+		if !isSignificant(content) || strings.HasPrefix(content, "---") || strings.HasPrefix(content, "%") {
+			continue
+		}
+
+		if indentation(content) > 0 {
+			continue
+		}
+
+		if strings.HasPrefix(content, "{") || strings.HasPrefix(content, "[") || strings.HasPrefix(content, "entries: ") {
+			return decodeFullIndex(io.MultiReader(prefix, reader), want)
+		}
+
+		if content == "entries:" || strings.HasPrefix(content, "entries: #") {
+			return decodeEntries(reader, want)
+		}
+	}
+}
+
+func decodeEntries(reader *bufio.Reader, want func(string) bool) (resource.HelmChartRepository, error) {
+	chunk := &bytes.Buffer{}
+	chunk.WriteString("entries:\n")
+
+	entriesIndent := 0
+	wanted := false
+	for {
+		line, err := reader.ReadString('\n')
+		if line == "" && err != nil {
+			if err == io.EOF {
+				break
+			}
+			return resource.HelmChartRepository{}, err
+		}
+
+		content := strings.TrimRight(line, " \t\r\n")
+		if isSignificant(content) {
+			indent := indentation(content)
+			if entriesIndent == 0 {
+				entriesIndent = indent
+			}
+
+			if indent < entriesIndent || indent == 0 {
+				break
+			}
+
+			item := strings.TrimSpace(content)
+			if indent == entriesIndent && item != "-" && !strings.HasPrefix(item, "- ") {
+				wanted = want(chartKey(item))
+			}
+		}
+
+		if wanted {
+			chunk.WriteString(strings.TrimRight(line, "\r\n"))
+			chunk.WriteString("\n")
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+
+	repo := resource.HelmChartRepository{}
+	if err := yaml.Unmarshal(chunk.Bytes(), &repo); err != nil {
+		return resource.HelmChartRepository{}, err
+	}
+
+	if repo.Entries == nil {
+		repo.Entries = map[string][]resource.HelmChartInfo{}
+	}
+
+	return repo, nil
+}
+
+func decodeFullIndex(r io.Reader, want func(string) bool) (resource.HelmChartRepository, error) {
+	repo := resource.HelmChartRepository{}
+	if err := yaml.NewDecoder(r).Decode(&repo); err != nil {
+		return resource.HelmChartRepository{}, err
+	}
+
+	for name := range repo.Entries {
+		if !want(name) {
+			delete(repo.Entries, name)
+		}
+	}
+
+	if repo.Entries == nil {
+		repo.Entries = map[string][]resource.HelmChartInfo{}
+	}
+
+	return repo, nil
+}
+
+// chartKey returns the name of the chart whose entry starts on the line
+func chartKey(line string) string {
+	key := yaml.MapSlice{}
+	if err := yaml.Unmarshal([]byte(line), &key); err == nil && len(key) == 1 {
+		if name, ok := key[0].Key.(string); ok {
+			return name
+		}
+	}
+
+	name := strings.SplitN(line, ":", 2)[0]
+	return strings.Trim(name, `"'`)
+}
+
+// isSignificant reports whether a line takes part in the structure of the
+// document, which blank lines and comments don't
+func isSignificant(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed != "" && !strings.HasPrefix(trimmed, "#")
+}
+
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// wantChart selects the charts configured in the source, or every chart if
+// there aren't any. The chart pattern has already been validated by
+// NewBackend.
+func wantChart(source resource.Source) func(string) bool {
+	matchPattern, _ := ChartPattern(source.ChartPattern)
+
+	return func(name string) bool {
+		if source.ChartName == "" && len(source.Charts) == 0 && source.ChartPattern == "" {
+			return true
+		}
+
+		if name == source.ChartName || (source.ChartPattern != "" && matchPattern(name)) {
+			return true
+		}
+
+		for _, chart := range source.Charts {
+			if name == chart {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// ChartPattern compiles a chart_pattern, which is a glob such as team-*, or a
+// regular expression when wrapped in slashes, such as /^team-(a|b)-/
+func ChartPattern(pattern string) (func(string) bool, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid chart_pattern %q: %v", pattern, err)
+		}
+
+		return re.MatchString, nil
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("Invalid chart_pattern %q: %v", pattern, err)
+	}
+
+	return func(name string) bool {
+		ok, _ := path.Match(pattern, name)
+		return ok
+	}, nil
+}
@@ -0,0 +1,368 @@
+package repository_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	resource "github.com/jghiloni/helm-resource"
+	"github.com/jghiloni/helm-resource/repository"
+	"gopkg.in/yaml.v2"
+)
+
+func TestFetchOnlyDecodesRequestedChart(t *testing.T) {
+	tests := []struct {
+		name     string
+		index    string
+		chart    string
+		expected []string
+	}{
+		{
+			name:     "helm layout",
+			index:    helmIndex,
+			chart:    "concourse",
+			expected: []string{"11.1.0", "11.0.0"},
+		},
+		{
+			name:     "last chart in the index",
+			index:    helmIndex,
+			chart:    "zookeeper",
+			expected: []string{"5.0.0"},
+		},
+		{
+			name: "quoted keys, comments and indented sequences",
+			index: `# generated by hand
+apiVersion: v1
+entries:
+
+    "postgresql":
+      - version: 8.6.4
+        name: postgresql
+    # the chart we want
+    'concourse':
+      - version: 11.1.0
+        name: concourse
+
+      - version: 11.0.0
+        name: concourse
+generated: "2020-06-05T14:01:19.673532266Z"
+`,
+			chart:    "concourse",
+			expected: []string{"11.1.0", "11.0.0"},
+		},
+		{
+			name:     "block scalars that look like keys",
+			index:    "apiVersion: v1\r\nentries:\r\n  concourse:\r\n  - description: |\r\n      postgresql:\r\n\r\n      - version: 1.0.0\r\n    version: 11.1.0\r\n  postgresql:\r\n  - version: 8.6.4\r\n",
+			chart:    "concourse",
+			expected: []string{"11.1.0"},
+		},
+		{
+			name:     "flow style",
+			index:    `{apiVersion: v1, entries: {concourse: [{version: 11.1.0}], postgresql: [{version: 8.6.4}]}}`,
+			chart:    "concourse",
+			expected: []string{"11.1.0"},
+		},
+		{
+			name:     "inline entries",
+			index:    "apiVersion: v1\nentries: {concourse: [{version: 11.1.0}], postgresql: [{version: 8.6.4}]}\n",
+			chart:    "concourse",
+			expected: []string{"11.1.0"},
+		},
+		{
+			name:     "missing chart",
+			index:    helmIndex,
+			chart:    "mysql",
+			expected: nil,
+		},
+		{
+			name:     "empty entries",
+			index:    "apiVersion: v1\nentries:\ngenerated: \"2020-06-05T14:01:19.673532266Z\"\n",
+			chart:    "concourse",
+			expected: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo, err := fetch(&indexClient{index: []byte(test.index)}, resource.Source{
+				RepositoryURL: "https://example.com/",
+				ChartName:     test.chart,
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+
+			for name := range repo.Entries {
+				if name != test.chart {
+					t.Fatalf("Expected only chart %q to be decoded, but %q was too", test.chart, name)
+				}
+			}
+
+			versions := []string{}
+			for _, info := range repo.Entries[test.chart] {
+				versions = append(versions, info.Version)
+			}
+
+			if strings.Join(versions, ",") != strings.Join(test.expected, ",") {
+				t.Fatalf("Expected versions %v but got %v", test.expected, versions)
+			}
+		})
+	}
+}
+
+func TestIndexFormats(t *testing.T) {
+	jsonIndex := `{
+  "apiVersion": "v1",
+  "generated": "2020-06-05T14:01:19.673532266Z",
+  "entries": {
+    "postgresql": [{"name": "postgresql", "version": "8.6.4"}],
+    "concourse": [
+      {"name": "concourse", "version": "11.1.0", "created": "2020-06-05T14:01:19.680138326Z"},
+      {"name": "concourse", "version": "11.0.0", "created": "2020-05-05T14:01:19.680138326Z"}
+    ],
+    "zookeeper": [{"name": "zookeeper", "version": "5.0.0"}]
+  }
+}`
+
+	tests := []struct {
+		name         string
+		indexPath    string
+		header       http.Header
+		index        []byte
+		expectedPath string
+	}{
+		{
+			name:         "default index path",
+			index:        []byte(helmIndex),
+			expectedPath: "/charts/index.yaml",
+		},
+		{
+			name:         "json by extension",
+			indexPath:    "index.json",
+			index:        []byte(jsonIndex),
+			expectedPath: "/charts/index.json",
+		},
+		{
+			name:         "json by content type",
+			header:       http.Header{"Content-Type": {"application/json; charset=utf-8"}},
+			index:        []byte(jsonIndex),
+			expectedPath: "/charts/index.yaml",
+		},
+		{
+			name:         "yaml content type overrides extension",
+			indexPath:    "index.json",
+			header:       http.Header{"Content-Type": {"application/x-yaml"}},
+			index:        []byte(helmIndex),
+			expectedPath: "/charts/index.json",
+		},
+		{
+			name:         "gzip content encoding",
+			header:       http.Header{"Content-Encoding": {"gzip"}},
+			index:        gzipped(t, helmIndex),
+			expectedPath: "/charts/index.yaml",
+		},
+		{
+			name:         "gzipped yaml file",
+			indexPath:    "index.yaml.gz",
+			header:       http.Header{"Content-Type": {"application/gzip"}},
+			index:        gzipped(t, helmIndex),
+			expectedPath: "/charts/index.yaml.gz",
+		},
+		{
+			name:         "gzipped json file in a subdirectory",
+			indexPath:    "v2/index.json.gz",
+			index:        gzipped(t, jsonIndex),
+			expectedPath: "/charts/v2/index.json.gz",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &indexClient{index: test.index, header: test.header}
+			repo, err := fetch(client, resource.Source{
+				RepositoryURL: "https://example.com/charts",
+				ChartName:     "concourse",
+				IndexPath:     test.indexPath,
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+
+			if client.path != test.expectedPath {
+				t.Fatalf("Expected index to be requested from %q but was %q", test.expectedPath, client.path)
+			}
+
+			if len(repo.Entries) != 1 || len(repo.Entries["concourse"]) != 2 {
+				t.Fatalf("Expected only the 2 concourse versions but got %v", repo.Entries)
+			}
+
+			if repo.Entries["concourse"][0].Version != "11.1.0" {
+				t.Fatalf("Expected version 11.1.0 but got %q", repo.Entries["concourse"][0].Version)
+			}
+		})
+	}
+}
+
+func BenchmarkIndex(b *testing.B) {
+	index := syntheticIndex(b)
+	client := &indexClient{index: index}
+	source := resource.Source{
+		RepositoryURL: "https://example.com/",
+		ChartName:     "chart-04999",
+	}
+
+	b.SetBytes(int64(len(index)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		repo, err := fetch(client, source)
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		if len(repo.Entries[source.ChartName]) != 10 {
+			b.Fatalf("Expected 10 versions but got %d", len(repo.Entries[source.ChartName]))
+		}
+	}
+}
+
+// BenchmarkDecodeFullIndex is the baseline of decoding every entry
+func BenchmarkDecodeFullIndex(b *testing.B) {
+	index := syntheticIndex(b)
+
+	b.SetBytes(int64(len(index)))
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		repo := resource.HelmChartRepository{}
+		if err := yaml.NewDecoder(bytes.NewReader(index)).Decode(&repo); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func gzipped(t *testing.T, contents string) []byte {
+	buf := &bytes.Buffer{}
+	writer := gzip.NewWriter(buf)
+	if _, err := writer.Write([]byte(contents)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+var (
+	syntheticIndexOnce  sync.Once
+	syntheticIndexBytes []byte
+)
+
+// syntheticIndex generates a ~50MB index of 10,000 charts with 10 versions each
+func syntheticIndex(b *testing.B) []byte {
+	syntheticIndexOnce.Do(func() {
+		buf := &bytes.Buffer{}
+		buf.WriteString("apiVersion: v1\nentries:\n")
+		for c := 0; c < 10000; c++ {
+			fmt.Fprintf(buf, "  chart-%05d:\n", c)
+			for v := 0; v < 10; v++ {
+				fmt.Fprintf(buf, `  - apiVersion: v2
+    appVersion: 1.%[2]d.0
+    created: "2020-06-05T14:01:19.680138326Z"
+    description: |
+      Chart number %[1]d is a synthetic chart used to benchmark decoding very
+      large repository indexes, with a description long enough to matter.
+    digest: 86f5f3bd5380eaf6331b6413b5628ceed7116f316ab83c302191c319d168a2d7
+    home: https://example.com/charts/chart-%05[1]d
+    keywords:
+    - synthetic
+    - benchmark
+    maintainers:
+    - email: maintainer@example.com
+      name: maintainer
+    name: chart-%05[1]d
+    sources:
+    - https://github.com/example/chart-%05[1]d
+    urls:
+    - https://example.com/charts/chart-%05[1]d-%[2]d.0.0.tgz
+    version: %[2]d.0.0
+`, c, v)
+			}
+		}
+		buf.WriteString("generated: \"2020-06-05T14:01:19.673532266Z\"\n")
+		syntheticIndexBytes = buf.Bytes()
+	})
+
+	return syntheticIndexBytes
+}
+
+func fetch(client resource.HTTPClient, source resource.Source) (resource.HelmChartRepository, error) {
+	backend, err := repository.NewBackend(client, source)
+	if err != nil {
+		return resource.HelmChartRepository{}, err
+	}
+
+	return backend.Index(context.Background())
+}
+
+type indexClient struct {
+	index  []byte
+	header http.Header
+	path   string
+}
+
+func (i *indexClient) Do(req *http.Request) (*http.Response, error) {
+	i.path = req.URL.Path
+
+	header := http.Header{}
+	for name, values := range i.header {
+		header[name] = values
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Header:     header,
+		Body:       ioutil.NopCloser(bytes.NewReader(i.index)),
+		Request:    req,
+	}, nil
+}
+
+const helmIndex = `apiVersion: v1
+entries:
+  concourse:
+  - apiVersion: v1
+    appVersion: 6.3.0
+    description: Concourse is a simple and scalable CI system.
+    name: concourse
+    urls:
+    - https://concourse-charts.storage.googleapis.com/concourse-11.1.0.tgz
+    version: 11.1.0
+  - apiVersion: v1
+    appVersion: 6.2.0
+    description: Concourse is a simple and scalable CI system.
+    name: concourse
+    urls:
+    - https://concourse-charts.storage.googleapis.com/concourse-11.0.0.tgz
+    version: 11.0.0
+  postgresql:
+  - apiVersion: v1
+    appVersion: 11.7.0
+    name: postgresql
+    version: 8.6.4
+  zookeeper:
+  - apiVersion: v1
+    name: zookeeper
+    version: 5.0.0
+generated: "2020-06-05T14:01:19.673532266Z"
+`
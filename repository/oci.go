@@ -0,0 +1,465 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	resource "github.com/jghiloni/helm-resource"
+)
+
+const (
+	OCIScheme               = "oci"
+	OCIManifestMediaType    = "application/vnd.oci.image.manifest.v1+json"
+	HelmChartLayerMediaType = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+	HelmProvenanceMediaType = "application/vnd.cncf.helm.chart.provenance.v1.prov"
+)
+
+type OCIDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type OCIManifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	Config        OCIDescriptor     `json:"config"`
+	Layers        []OCIDescriptor   `json:"layers"`
+	Annotations   map[string]string `json:"annotations"`
+}
+
+// ChartLayer returns the layer holding the packaged chart.
+func (m OCIManifest) ChartLayer() (OCIDescriptor, error) {
+	return m.Layer(HelmChartLayerMediaType)
+}
+
+// Layer returns the first layer with the given media type.
+func (m OCIManifest) Layer(mediaType string) (OCIDescriptor, error) {
+	for _, layer := range m.Layers {
+		if layer.MediaType == mediaType {
+			return layer, nil
+		}
+	}
+
+	return OCIDescriptor{}, fmt.Errorf("No layer with media type %q found in manifest", mediaType)
+}
+
+func IsOCI(repositoryURL string) bool {
+	return strings.HasPrefix(strings.ToLower(repositoryURL), OCIScheme+"://")
+}
+
+// ociBackend reads charts stored as OCI artifacts in a registry, listing the
+// tags of the chart's repository as its versions.
+type ociBackend struct {
+	registry *ociRegistry
+	remote   Backend
+
+	// manifests memoizes the manifest of each version, which describing and
+	// downloading a chart both need
+	manifests map[string]ociManifest
+}
+
+type ociManifest struct {
+	manifest OCIManifest
+	digest   string
+}
+
+func newOCIBackend(client resource.HTTPClient, source resource.Source) (Backend, error) {
+	if len(source.Charts) > 0 || source.ChartPattern != "" {
+		return nil, fmt.Errorf("The charts and chart_pattern options are not supported for OCI registries")
+	}
+
+	registry, err := newOCIRegistry(client, source)
+	if err != nil {
+		return nil, err
+	}
+
+	remote, err := newHTTPBackend(client, source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ociBackend{registry: registry, remote: remote, manifests: map[string]ociManifest{}}, nil
+}
+
+func (b *ociBackend) Index(ctx context.Context) (resource.HelmChartRepository, error) {
+	tags, err := b.registry.tags(ctx)
+	if err != nil {
+		return resource.HelmChartRepository{}, err
+	}
+
+	infos := make([]resource.HelmChartInfo, 0, len(tags))
+	for _, tag := range tags {
+		infos = append(infos, resource.HelmChartInfo{
+			Version: tagToVersion(tag),
+			URLs:    []string{fmt.Sprintf("%s://%s/%s:%s", OCIScheme, b.registry.host, b.registry.name, tag)},
+		})
+	}
+
+	return resource.HelmChartRepository{
+		Entries: map[string][]resource.HelmChartInfo{
+			b.registry.source.ChartName: infos,
+		},
+	}, nil
+}
+
+// Describe fills in the chart details that an index.yaml would otherwise
+// provide from the version's manifest and its config blob, which holds
+// Chart.yaml as JSON. The manifest digest is returned as metadata.
+func (b *ociBackend) Describe(ctx context.Context, info resource.HelmChartInfo) (resource.HelmChartInfo, []resource.MetadataField, error) {
+	manifest, manifestDigest, err := b.manifest(ctx, info.Version)
+	if err != nil {
+		return info, nil, err
+	}
+
+	layer, err := manifest.ChartLayer()
+	if err != nil {
+		return info, nil, err
+	}
+
+	blob, err := b.blob(ctx, manifest.Config.Digest)
+	if err != nil {
+		return info, nil, err
+	}
+	defer blob.Close()
+
+	config := resource.HelmChartInfo{}
+	if err = json.NewDecoder(blob).Decode(&config); err != nil {
+		return info, nil, err
+	}
+
+	config.Digest = strings.TrimPrefix(layer.Digest, "sha256:")
+	config.URLs = info.URLs
+	config.Created = info.Created
+	if created, err := time.Parse(time.RFC3339, manifest.Annotations["org.opencontainers.image.created"]); err == nil {
+		config.Created = created
+	}
+
+	return config, []resource.MetadataField{{Name: "manifest_digest", Value: manifestDigest}}, nil
+}
+
+// Open pulls the chart layer of a chart listed in the index.
+func (b *ociBackend) Open(ctx context.Context, chartURL string) (io.ReadCloser, error) {
+	if !IsOCI(chartURL) {
+		return b.remote.Open(ctx, chartURL)
+	}
+
+	return b.layer(ctx, chartURL, HelmChartLayerMediaType)
+}
+
+// Provenance pulls the provenance layer pushed along with the chart.
+func (b *ociBackend) Provenance(ctx context.Context, chartURL string) (io.ReadCloser, error) {
+	if !IsOCI(chartURL) {
+		return b.remote.Provenance(ctx, chartURL)
+	}
+
+	return b.layer(ctx, chartURL, HelmProvenanceMediaType)
+}
+
+func (b *ociBackend) layer(ctx context.Context, chartURL string, mediaType string) (io.ReadCloser, error) {
+	prefix := fmt.Sprintf("%s://%s/%s:", OCIScheme, b.registry.host, b.registry.name)
+	if !strings.HasPrefix(chartURL, prefix) {
+		return nil, fmt.Errorf("%q is not a version of %s/%s", chartURL, b.registry.host, b.registry.name)
+	}
+
+	manifest, _, err := b.manifest(ctx, tagToVersion(strings.TrimPrefix(chartURL, prefix)))
+	if err != nil {
+		return nil, err
+	}
+
+	layer, err := manifest.Layer(mediaType)
+	if err != nil {
+		return nil, err
+	}
+
+	return b.blob(ctx, layer.Digest)
+}
+
+// manifest resolves the manifest for the given chart version, returning it
+// along with its digest.
+func (b *ociBackend) manifest(ctx context.Context, version string) (OCIManifest, string, error) {
+	if cached, ok := b.manifests[version]; ok {
+		return cached.manifest, cached.digest, nil
+	}
+
+	resp, err := b.registry.get(ctx, b.registry.url("manifests", versionToTag(version)), OCIManifestMediaType)
+	if err != nil {
+		return OCIManifest{}, "", err
+	}
+	defer resp.Body.Close()
+
+	manifest := OCIManifest{}
+	if err = json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return OCIManifest{}, "", err
+	}
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	b.manifests[version] = ociManifest{manifest: manifest, digest: digest}
+
+	return manifest, digest, nil
+}
+
+func (b *ociBackend) blob(ctx context.Context, digest string) (io.ReadCloser, error) {
+	resp, err := b.registry.get(ctx, b.registry.url("blobs", digest))
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// OCI tags may not contain a +, so helm stores build metadata with an _
+// instead
+func versionToTag(version string) string {
+	return strings.Replace(version, "+", "_", -1)
+}
+
+func tagToVersion(tag string) string {
+	return strings.Replace(tag, "_", "+", -1)
+}
+
+type ociRegistry struct {
+	client resource.HTTPClient
+	source resource.Source
+	host   string
+	name   string
+	auth   string
+}
+
+func newOCIRegistry(client resource.HTTPClient, source resource.Source) (*ociRegistry, error) {
+	u, err := url.Parse(source.RepositoryURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != OCIScheme || u.Host == "" {
+		return nil, fmt.Errorf("%q is not a valid OCI repository URL", source.RepositoryURL)
+	}
+
+	// both oci://registry/namespace and oci://registry/namespace/chart are
+	// accepted
+	name := strings.Trim(u.Path, "/")
+	if path.Base(name) != source.ChartName {
+		name = path.Join(name, source.ChartName)
+	}
+
+	registry := &ociRegistry{
+		client: client,
+		source: source,
+		host:   u.Host,
+		name:   name,
+	}
+
+	if source.Token != "" {
+		registry.auth = "Bearer " + source.Token
+	}
+
+	return registry, nil
+}
+
+func (r *ociRegistry) url(kind, reference string) string {
+	return fmt.Sprintf("https://%s/v2/%s/%s/%s", r.host, r.name, kind, reference)
+}
+
+func (r *ociRegistry) tags(ctx context.Context) ([]string, error) {
+	tags := []string{}
+	next := fmt.Sprintf("https://%s/v2/%s/tags/list", r.host, r.name)
+
+	for next != "" {
+		resp, err := r.get(ctx, next)
+		if err != nil {
+			return nil, err
+		}
+
+		page := struct {
+			Tags []string `json:"tags"`
+		}{}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, page.Tags...)
+
+		next, err = nextPage(resp)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tags, nil
+}
+
+var linkPattern = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="?next"?`)
+
+func nextPage(resp *http.Response) (string, error) {
+	matches := linkPattern.FindStringSubmatch(resp.Header.Get("Link"))
+	if matches == nil {
+		return "", nil
+	}
+
+	ref, err := url.Parse(matches[1])
+	if err != nil {
+		return "", err
+	}
+
+	return resp.Request.URL.ResolveReference(ref).String(), nil
+}
+
+func (r *ociRegistry) get(ctx context.Context, u string, accept ...string) (*http.Response, error) {
+	resp, err := r.do(ctx, u, accept)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized && r.auth == "" {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+
+		if err = r.authorize(ctx, challenge); err != nil {
+			return nil, err
+		}
+
+		resp, err = r.do(ctx, u, accept)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Received bad HTTP response: %q", resp.Status)
+	}
+
+	return resp, nil
+}
+
+func (r *ociRegistry) do(ctx context.Context, u string, accept []string) (*http.Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, mediaType := range accept {
+		httpReq.Header.Add("Accept", mediaType)
+	}
+
+	if r.auth != "" {
+		httpReq.Header.Set("Authorization", r.auth)
+	}
+
+	for name, value := range r.source.Headers {
+		httpReq.Header.Set(name, value)
+	}
+
+	return r.client.Do(httpReq)
+}
+
+// authorize answers a WWW-Authenticate challenge, either by using basic auth
+// directly or by exchanging the credentials for a bearer token
+func (r *ociRegistry) authorize(ctx context.Context, challenge string) error {
+	scheme, params := parseChallenge(challenge)
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if r.source.Username == "" {
+			return fmt.Errorf("Registry %q requires credentials", r.host)
+		}
+
+		httpReq := &http.Request{Header: http.Header{}}
+		httpReq.SetBasicAuth(r.source.Username, r.source.Password)
+		r.auth = httpReq.Header.Get("Authorization")
+
+		return nil
+	case "bearer":
+		token, err := r.fetchToken(ctx, params)
+		if err != nil {
+			return err
+		}
+
+		r.auth = "Bearer " + token
+
+		return nil
+	}
+
+	return fmt.Errorf("Unsupported authentication challenge %q", challenge)
+}
+
+func (r *ociRegistry) fetchToken(ctx context.Context, params map[string]string) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Scheme == "" {
+		return "", fmt.Errorf("Invalid token realm %q", params["realm"])
+	}
+
+	scope := params["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", r.name)
+	}
+
+	query := realm.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	if r.source.Username != "" {
+		httpReq.SetBasicAuth(r.source.Username, r.source.Password)
+	}
+
+	resp, err := r.client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("Received bad HTTP response fetching registry token: %q", resp.Status)
+	}
+
+	payload := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return "", err
+	}
+
+	if payload.Token != "" {
+		return payload.Token, nil
+	}
+
+	if payload.AccessToken != "" {
+		return payload.AccessToken, nil
+	}
+
+	return "", fmt.Errorf("Registry token response did not include a token")
+}
+
+var challengeParamPattern = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+func parseChallenge(challenge string) (string, map[string]string) {
+	parts := strings.SplitN(strings.TrimSpace(challenge), " ", 2)
+
+	params := map[string]string{}
+	if len(parts) == 2 {
+		for _, match := range challengeParamPattern.FindAllStringSubmatch(parts[1], -1) {
+			params[strings.ToLower(match[1])] = match[2]
+		}
+	}
+
+	return parts[0], params
+}
@@ -0,0 +1,243 @@
+package repository
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	resource "github.com/jghiloni/helm-resource"
+)
+
+const (
+	S3Scheme        = "s3"
+	defaultS3Region = "us-east-1"
+
+	// emptyPayloadHash is the SHA-256 of an empty request body
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// s3Backend reads a repository stored in an S3 bucket, requesting objects from
+// their HTTP endpoint with requests signed with AWS Signature Version 4.
+type s3Backend struct {
+	client resource.HTTPClient
+	source resource.Source
+}
+
+func newS3Backend(client resource.HTTPClient, source resource.Source) (Backend, error) {
+	return &s3Backend{client: client, source: source}, nil
+}
+
+func (b *s3Backend) Index(ctx context.Context) (resource.HelmChartRepository, error) {
+	u, err := IndexURL(b.source)
+	if err != nil {
+		return resource.HelmChartRepository{}, err
+	}
+
+	return fetchIndex(b.source, u, func(header http.Header) (*http.Response, error) {
+		return b.get(ctx, u, header)
+	})
+}
+
+// Open downloads an object from S3 with a signed request. Charts listed with
+// an HTTP URL are downloaded like from any other HTTP repository, without the
+// AWS credentials.
+func (b *s3Backend) Open(ctx context.Context, chartURL string) (io.ReadCloser, error) {
+	u, err := ResolveChartURL(b.source, chartURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if isHTTP(u) {
+		return download(ctx, b.client, b.source, u)
+	}
+
+	if !strings.EqualFold(u.Scheme, S3Scheme) {
+		return nil, fmt.Errorf("Refusing to open %s listed by %s", u.String(), b.source.RepositoryURL)
+	}
+
+	resp, err := b.get(ctx, u, http.Header{})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Received bad HTTP response downloading %s: %q", u.String(), resp.Status)
+	}
+
+	return resp.Body, nil
+}
+
+func (b *s3Backend) Describe(ctx context.Context, info resource.HelmChartInfo) (resource.HelmChartInfo, []resource.MetadataField, error) {
+	return info, nil, nil
+}
+
+func (b *s3Backend) Provenance(ctx context.Context, chartURL string) (io.ReadCloser, error) {
+	return openProvenance(ctx, b, b.source, chartURL)
+}
+
+// get requests the object named by an s3:// URL from its HTTP endpoint
+func (b *s3Backend) get(ctx context.Context, u *url.URL, header http.Header) (*http.Response, error) {
+	objectURL, err := s3ObjectURL(b.source, u)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, objectURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range header {
+		httpReq.Header[name] = values
+	}
+
+	signS3(httpReq, b.source, time.Now())
+
+	return b.client.Do(httpReq)
+}
+
+// s3ObjectURL returns the HTTP URL of the object named by an s3://bucket/key
+// URL. Objects are addressed virtual-host style on AWS, and path style on a
+// custom endpoint such as MinIO.
+func s3ObjectURL(source resource.Source, u *url.URL) (*url.URL, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("%q is not a valid S3 URL", u.String())
+	}
+
+	key := strings.TrimPrefix(u.Path, "/")
+	if source.Endpoint == "" {
+		return &url.URL{
+			Scheme: "https",
+			Host:   fmt.Sprintf("%s.s3.%s.amazonaws.com", u.Host, s3Region(source)),
+			Path:   "/" + key,
+		}, nil
+	}
+
+	endpoint, err := url.ParseRequestURI(source.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid endpoint %q: %v", source.Endpoint, err)
+	}
+
+	return &url.URL{
+		Scheme: endpoint.Scheme,
+		Host:   endpoint.Host,
+		Path:   strings.TrimSuffix(endpoint.Path, "/") + "/" + u.Host + "/" + key,
+	}, nil
+}
+
+func s3Region(source resource.Source) string {
+	if source.AWSRegion != "" {
+		return source.AWSRegion
+	}
+
+	return defaultS3Region
+}
+
+// signS3 signs a bodiless request with AWS Signature Version 4. The host, the
+// x-amz-* headers and any headers already set on the request are signed, so
+// it has to be called once the request is otherwise complete. Requests are
+// left anonymous if there are no credentials, for public buckets.
+func signS3(httpReq *http.Request, source resource.Source, now time.Time) {
+	if source.AWSAccessKeyID == "" || source.AWSSecretAccessKey == "" {
+		return
+	}
+
+	now = now.UTC()
+	date := now.Format("20060102")
+	scope := strings.Join([]string{date, s3Region(source), "s3", "aws4_request"}, "/")
+
+	httpReq.Header.Set("X-Amz-Date", now.Format("20060102T150405Z"))
+	httpReq.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+
+	// the path is sent exactly as it was signed
+	httpReq.URL.RawPath = s3Escape(httpReq.URL.Path, false)
+
+	headers := map[string]string{"host": httpReq.URL.Host}
+	for name, values := range httpReq.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := &strings.Builder{}
+	for _, name := range names {
+		fmt.Fprintf(canonicalHeaders, "%s:%s\n", name, strings.Join(strings.Fields(headers[name]), " "))
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		httpReq.Method,
+		httpReq.URL.RawPath,
+		s3CanonicalQuery(httpReq.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		now.Format("20060102T150405Z"),
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := []byte("AWS4" + source.AWSSecretAccessKey)
+	for _, part := range []string{date, s3Region(source), "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	httpReq.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		source.AWSAccessKeyID, scope, signedHeaders, signature))
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	params := []string{}
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, s3Escape(name, true)+"="+s3Escape(value, true))
+		}
+	}
+	sort.Strings(params)
+
+	return strings.Join(params, "&")
+}
+
+// s3Escape percent-encodes everything but the unreserved characters of RFC
+// 3986, which is stricter than net/url
+func s3Escape(s string, escapeSlash bool) string {
+	escaped := &strings.Builder{}
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~':
+			escaped.WriteByte(b)
+		case b == '/' && !escapeSlash:
+			escaped.WriteByte(b)
+		default:
+			fmt.Fprintf(escaped, "%%%02X", b)
+		}
+	}
+
+	return escaped.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
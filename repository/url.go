@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"net/url"
+	"strings"
+
+	resource "github.com/jghiloni/helm-resource"
+)
+
+// IndexURL returns the URL of the index of an HTTP repository, resolving the
+// configured index path against the repository URL.
+func IndexURL(source resource.Source) (*url.URL, error) {
+	indexPath := source.IndexPath
+	if indexPath == "" {
+		indexPath = defaultIndexPath
+	}
+
+	return resolve(source.RepositoryURL, indexPath)
+}
+
+// ResolveChartURL returns the URL to download a file listed in a chart's urls
+// from. Relative URLs are resolved against the repository URL, the same way
+// helm resolves them.
+func ResolveChartURL(source resource.Source, chartURL string) (*url.URL, error) {
+	if IsGit(source.RepositoryURL) {
+		return resolve(gitBaseURL(source.RepositoryURL), chartURL)
+	}
+
+	return resolve(source.RepositoryURL, chartURL)
+}
+
+// resolve resolves ref against base as an RFC 3986 reference. The base is
+// always treated as a directory, since repository URLs are usually given
+// without a trailing slash, and its query string is kept unless ref has one of
+// its own so that signed and tokenized repository URLs keep working.
+func resolve(base string, ref string) (*url.URL, error) {
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	if refURL.IsAbs() {
+		return refURL, nil
+	}
+
+	baseURL, err := url.ParseRequestURI(base)
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL.Path = strings.TrimSuffix(baseURL.Path, "/") + "/"
+	if baseURL.RawPath != "" {
+		baseURL.RawPath = strings.TrimSuffix(baseURL.RawPath, "/") + "/"
+	}
+
+	resolved := baseURL.ResolveReference(refURL)
+	if refURL.RawQuery == "" && !refURL.ForceQuery {
+		resolved.RawQuery = baseURL.RawQuery
+	}
+
+	return resolved, nil
+}
@@ -0,0 +1,97 @@
+package repository_test
+
+import (
+	"testing"
+
+	resource "github.com/jghiloni/helm-resource"
+	"github.com/jghiloni/helm-resource/repository"
+)
+
+func TestIndexURL(t *testing.T) {
+	tests := []struct {
+		repositoryURL string
+		indexPath     string
+		expected      string
+	}{
+		{"https://example.com", "", "https://example.com/index.yaml"},
+		{"https://example.com/charts", "", "https://example.com/charts/index.yaml"},
+		{"https://example.com/charts/", "", "https://example.com/charts/index.yaml"},
+		{"https://example.com/charts", "stable/index.json", "https://example.com/charts/stable/index.json"},
+		{"https://example.com/charts/stable", "../incubator/index.yaml", "https://example.com/charts/incubator/index.yaml"},
+		{"https://example.com/charts", "/index.yaml", "https://example.com/index.yaml"},
+		{"https://example.com/charts", "https://mirror.example.com/index.yaml", "https://mirror.example.com/index.yaml"},
+		{
+			"https://bucket.s3.amazonaws.com/charts?X-Amz-Signature=abc&X-Amz-Expires=300",
+			"",
+			"https://bucket.s3.amazonaws.com/charts/index.yaml?X-Amz-Signature=abc&X-Amz-Expires=300",
+		},
+		{
+			"https://storage.googleapis.com/bucket/charts/?token=a%2Fb",
+			"index.yaml?token=c",
+			"https://storage.googleapis.com/bucket/charts/index.yaml?token=c",
+		},
+		{"https://example.com/my%2Fcharts", "", "https://example.com/my%2Fcharts/index.yaml"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.repositoryURL+" "+test.indexPath, func(t *testing.T) {
+			u, err := repository.IndexURL(resource.Source{RepositoryURL: test.repositoryURL, IndexPath: test.indexPath})
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+
+			if u.String() != test.expected {
+				t.Fatalf("Expected %q but got %q", test.expected, u.String())
+			}
+		})
+	}
+}
+
+func TestResolveChartURL(t *testing.T) {
+	tests := []struct {
+		repositoryURL string
+		chartURL      string
+		expected      string
+	}{
+		{"https://example.com", "concourse-11.1.0.tgz", "https://example.com/concourse-11.1.0.tgz"},
+		{"https://example.com/charts", "concourse-11.1.0.tgz", "https://example.com/charts/concourse-11.1.0.tgz"},
+		{"https://example.com/charts/", "packages/concourse-11.1.0.tgz", "https://example.com/charts/packages/concourse-11.1.0.tgz"},
+		{"https://example.com/charts/stable", "../packages/concourse-11.1.0.tgz", "https://example.com/charts/packages/concourse-11.1.0.tgz"},
+		{"https://example.com/charts/stable", "../../../concourse-11.1.0.tgz", "https://example.com/concourse-11.1.0.tgz"},
+		{"https://example.com/charts", "/packages/concourse-11.1.0.tgz", "https://example.com/packages/concourse-11.1.0.tgz"},
+		{"https://example.com/charts", "//cdn.example.com/concourse-11.1.0.tgz", "https://cdn.example.com/concourse-11.1.0.tgz"},
+		{
+			"https://example.com/charts",
+			"https://concourse-charts.storage.googleapis.com/concourse-11.1.0.tgz",
+			"https://concourse-charts.storage.googleapis.com/concourse-11.1.0.tgz",
+		},
+		{
+			"https://bucket.s3.amazonaws.com/charts?X-Amz-Signature=abc",
+			"concourse-11.1.0.tgz",
+			"https://bucket.s3.amazonaws.com/charts/concourse-11.1.0.tgz?X-Amz-Signature=abc",
+		},
+		{
+			"https://bucket.s3.amazonaws.com/charts?X-Amz-Signature=abc",
+			"concourse-11.1.0.tgz?X-Amz-Signature=def",
+			"https://bucket.s3.amazonaws.com/charts/concourse-11.1.0.tgz?X-Amz-Signature=def",
+		},
+		{
+			"https://gitlab.example.com/api/v4/projects/1/packages/helm/stable",
+			"charts/concourse-11.1.0.tgz",
+			"https://gitlab.example.com/api/v4/projects/1/packages/helm/stable/charts/concourse-11.1.0.tgz",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.repositoryURL+" "+test.chartURL, func(t *testing.T) {
+			u, err := repository.ResolveChartURL(resource.Source{RepositoryURL: test.repositoryURL}, test.chartURL)
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+
+			if u.String() != test.expected {
+				t.Fatalf("Expected %q but got %q", test.expected, u.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package resource
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultRetryAttempts = 3
+	defaultRetryMaxWait  = 30 * time.Second
+	retryBaseWait        = time.Second
+)
+
+// retryClient retries idempotent requests that fail with a connection error,
+// a 429 or a 5xx, waiting with jittered exponential backoff between attempts
+// unless the server says how long to wait with Retry-After.
+type retryClient struct {
+	client   HTTPClient
+	retries  int
+	maxWait  time.Duration
+	baseWait time.Duration
+}
+
+func newRetryClient(client HTTPClient, source Source) (HTTPClient, error) {
+	retries := defaultRetryAttempts
+	if source.RetryAttempts != nil {
+		retries = *source.RetryAttempts
+	}
+
+	maxWait := defaultRetryMaxWait
+	if source.RetryMaxWait != "" {
+		var err error
+		if maxWait, err = time.ParseDuration(source.RetryMaxWait); err != nil {
+			return nil, fmt.Errorf("Invalid retry_max_wait %q: %v", source.RetryMaxWait, err)
+		}
+	}
+
+	if retries <= 0 {
+		return client, nil
+	}
+
+	return &retryClient{
+		client:   client,
+		retries:  retries,
+		maxWait:  maxWait,
+		baseWait: retryBaseWait,
+	}, nil
+}
+
+func (r *retryClient) Do(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return r.client.Do(req)
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := r.client.Do(req)
+		if attempt >= r.retries || req.Context().Err() != nil || !shouldRetry(resp, err) {
+			return resp, err
+		}
+
+		wait := r.backoff(attempt)
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+				wait = retryAfter
+			}
+
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		if wait > r.maxWait {
+			wait = r.maxWait
+		}
+
+		log.Printf("%s %s://%s%s failed (%s), retrying in %s", req.Method, req.URL.Scheme, req.URL.Host, req.URL.Path, reason, wait)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff returns a random wait between half and all of the exponentially
+// increasing wait for the attempt
+func (r *retryClient) backoff(attempt int) time.Duration {
+	wait := r.baseWait << uint(attempt)
+	if wait <= 0 || wait > r.maxWait {
+		wait = r.maxWait
+	}
+
+	half := int64(wait / 2)
+	if half <= 0 {
+		return wait
+	}
+
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return transient(err)
+	}
+
+	return resp.StatusCode == http.StatusTooManyRequests ||
+		(resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented)
+}
+
+// transient reports whether a request failed in a way that trying again might
+// fix. Anything else, such as an unsupported URL scheme or a TLS handshake
+// that was rejected, fails the same way on every attempt.
+func transient(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsTemporary {
+		return true
+	}
+
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
+
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if when, err := http.ParseTime(value); err == nil {
+		wait := time.Until(when)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+
+	return 0, false
+}
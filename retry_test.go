@@ -0,0 +1,242 @@
+package resource_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	resource "github.com/jghiloni/helm-resource"
+)
+
+func TestRetries(t *testing.T) {
+	attempts := 3
+
+	source := resource.Source{
+		RetryAttempts: &attempts,
+		RetryMaxWait:  "20ms",
+	}
+
+	request := func(t *testing.T, source resource.Source, method string, url string) (*http.Response, error) {
+		client, err := resource.NewClient(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest(method, url, strings.NewReader(""))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return client.Do(req)
+	}
+
+	t.Run("It retries transient failures", func(t *testing.T) {
+		server, calls := newFlakyServer(2, http.StatusBadGateway, "")
+		defer server.Close()
+
+		resp, err := request(t, source, http.MethodGet, server.URL)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected a 200 but got %q", resp.Status)
+		}
+
+		if *calls != 3 {
+			t.Fatalf("Expected 3 requests but there were %d", *calls)
+		}
+	})
+
+	t.Run("It gives up after retry_attempts", func(t *testing.T) {
+		server, calls := newFlakyServer(10, http.StatusServiceUnavailable, "")
+		defer server.Close()
+
+		resp, err := request(t, source, http.MethodGet, server.URL)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("Expected a 503 but got %q", resp.Status)
+		}
+
+		if *calls != 4 {
+			t.Fatalf("Expected 4 requests but there were %d", *calls)
+		}
+	})
+
+	t.Run("It honors Retry-After up to retry_max_wait", func(t *testing.T) {
+		server, calls := newFlakyServer(1, http.StatusTooManyRequests, "60")
+		defer server.Close()
+
+		start := time.Now()
+		resp, err := request(t, source, http.MethodGet, server.URL)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		elapsed := time.Since(start)
+
+		if resp.StatusCode != http.StatusOK || *calls != 2 {
+			t.Fatalf("Expected a 200 after 2 requests but got %q after %d", resp.Status, *calls)
+		}
+
+		if elapsed < 20*time.Millisecond || elapsed > 10*time.Second {
+			t.Fatalf("Expected to wait retry_max_wait, but waited %s", elapsed)
+		}
+	})
+
+	t.Run("It doesn't retry client errors", func(t *testing.T) {
+		server, calls := newFlakyServer(1, http.StatusNotFound, "")
+		defer server.Close()
+
+		resp, err := request(t, source, http.MethodGet, server.URL)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		if resp.StatusCode != http.StatusNotFound || *calls != 1 {
+			t.Fatalf("Expected a single 404 but got %q after %d requests", resp.Status, *calls)
+		}
+	})
+
+	t.Run("It doesn't retry non-idempotent requests", func(t *testing.T) {
+		server, calls := newFlakyServer(1, http.StatusBadGateway, "")
+		defer server.Close()
+
+		resp, err := request(t, source, http.MethodPost, server.URL)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		if resp.StatusCode != http.StatusBadGateway || *calls != 1 {
+			t.Fatalf("Expected a single 502 but got %q after %d requests", resp.Status, *calls)
+		}
+	})
+
+	t.Run("It can be disabled", func(t *testing.T) {
+		server, calls := newFlakyServer(1, http.StatusBadGateway, "")
+		defer server.Close()
+
+		none := 0
+		resp, err := request(t, resource.Source{RetryAttempts: &none}, http.MethodGet, server.URL)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+
+		if resp.StatusCode != http.StatusBadGateway || *calls != 1 {
+			t.Fatalf("Expected a single 502 but got %q after %d requests", resp.Status, *calls)
+		}
+	})
+
+	t.Run("It stops retrying when the request is cancelled", func(t *testing.T) {
+		server, calls := newFlakyServer(10, http.StatusBadGateway, "60")
+		defer server.Close()
+
+		client, err := resource.NewClient(resource.Source{RetryAttempts: &attempts})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+		if _, err = client.Do(req); err != context.DeadlineExceeded {
+			t.Fatalf("Expected the deadline to be exceeded, but got %v", err)
+		}
+
+		if *calls != 1 || time.Since(start) > 5*time.Second {
+			t.Fatalf("Expected to stop waiting after the first request, but made %d in %s", *calls, time.Since(start))
+		}
+	})
+
+	t.Run("It retries dropped connections", func(t *testing.T) {
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			calls++
+			if calls <= 2 {
+				conn, _, err := w.(http.Hijacker).Hijack()
+				if err == nil {
+					conn.Close()
+				}
+				return
+			}
+
+			w.Write([]byte("ok"))
+		}))
+		defer server.Close()
+
+		resp, err := request(t, source, http.MethodGet, server.URL)
+		if err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+		resp.Body.Close()
+
+		if calls != 3 {
+			t.Fatalf("Expected 3 requests but there were %d", calls)
+		}
+	})
+
+	t.Run("It doesn't retry errors that won't go away", func(t *testing.T) {
+		// a plain HTTP server fails every TLS handshake
+		server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte("ok"))
+		}))
+		connections := 0
+		server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				connections++
+			}
+		}
+		server.Start()
+		defer server.Close()
+
+		if _, err := request(t, source, http.MethodGet, strings.Replace(server.URL, "http://", "https://", 1)); err == nil {
+			t.Fatalf("An error should have occurred but none did")
+		}
+
+		if connections != 1 {
+			t.Fatalf("Expected 1 connection but there were %d", connections)
+		}
+
+		if _, err := request(t, source, http.MethodGet, "ftp://example.com/index.yaml"); err == nil {
+			t.Fatalf("An error should have occurred but none did")
+		}
+	})
+
+	t.Run("It rejects an invalid retry_max_wait", func(t *testing.T) {
+		if _, err := resource.NewClient(resource.Source{RetryMaxWait: "soon"}); err == nil {
+			t.Fatalf("An error should have occurred but none did")
+		}
+	})
+}
+
+// newFlakyServer returns a server that fails the first failures requests with
+// the given status before succeeding
+func newFlakyServer(failures int, status int, retryAfter string) (*httptest.Server, *int) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls <= failures {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(status)
+			return
+		}
+
+		w.Write([]byte("ok"))
+	}))
+
+	return server, &calls
+}
@@ -1,19 +1,81 @@
 package resource
 
-import "time"
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
 
 type Source struct {
-	RepositoryURL      string `json:"repository_url"`
-	ChartName          string `json:"chart"`
-	Username           string `json:"username"`
-	Password           string `json:"password"`
-	SkipTLSValidation  bool   `json:"skip_tls_validation"`
-	SortBy             string `json:"sort_by"`
-	IncludePreReleases bool   `json:"include_pre_releases"`
+	RepositoryURL      string            `json:"repository_url"`
+	ChartName          string            `json:"chart"`
+	Charts             []string          `json:"charts"`
+	ChartPattern       string            `json:"chart_pattern"`
+	IndexPath          string            `json:"index_path"`
+	Username           string            `json:"username"`
+	Password           string            `json:"password"`
+	Token              string            `json:"token"`
+	Headers            map[string]string `json:"headers"`
+	PassCredentialsAll bool              `json:"pass_credentials_all"`
+	AWSAccessKeyID     string            `json:"aws_access_key_id"`
+	AWSSecretAccessKey string            `json:"aws_secret_access_key"`
+	AWSRegion          string            `json:"aws_region"`
+	Endpoint           string            `json:"endpoint"`
+	SkipTLSValidation  bool              `json:"skip_tls_validation"`
+	CACert             string            `json:"ca_cert"`
+	ClientCert         string            `json:"client_cert"`
+	ClientKey          string            `json:"client_key"`
+	Timeout            string            `json:"timeout"`
+	CacheDir           string            `json:"cache_dir"`
+	RetryAttempts      *int              `json:"retry_attempts"`
+	RetryMaxWait       string            `json:"retry_max_wait"`
+	SortBy             string            `json:"sort_by"`
+	IncludePreReleases bool              `json:"include_pre_releases"`
+	InitialVersions    InitialVersions   `json:"initial_versions"`
+	VersionConstraint  string            `json:"version_constraint"`
+	SkipDeprecated     bool              `json:"skip_deprecated"`
+	Verify             bool              `json:"verify"`
+	Keyring            string            `json:"keyring"`
+}
+
+// InitialVersions is how many versions check reports when there is no cursor,
+// either a number or "all"
+type InitialVersions int
+
+const AllVersions InitialVersions = -1
+
+func (i *InitialVersions) UnmarshalJSON(data []byte) error {
+	// like the standard decoder, leave the default alone for null
+	if string(data) == "null" {
+		return nil
+	}
+
+	var all string
+	if err := json.Unmarshal(data, &all); err == nil {
+		if all != "all" {
+			return fmt.Errorf("Invalid initial_versions %q: must be a number or \"all\"", all)
+		}
+
+		*i = AllVersions
+		return nil
+	}
+
+	var n int
+	if err := json.Unmarshal(data, &n); err != nil || n < 0 {
+		return fmt.Errorf("Invalid initial_versions %s: must be a number or \"all\"", data)
+	}
+
+	*i = InitialVersions(n)
+	return nil
 }
 
 type Version struct {
+	Chart   string `json:"chart,omitempty"`
 	Version string `json:"version"`
+	Hash    string `json:"hash,omitempty"`
+	// Seen records the newest version of every chart matching chart_pattern
+	// as of this version, as a composite version
+	Seen string `json:"seen,omitempty"`
 }
 
 type MetadataField struct {
@@ -22,13 +84,43 @@ type MetadataField struct {
 }
 
 type HelmChartInfo struct {
-	Version     string    `yaml:"version"`
-	AppVersion  string    `yaml:"appVersion"`
-	APIVersion  string    `yaml:"apiVersion"`
-	Created     time.Time `yaml:"created"`
-	Description string    `yaml:"description"`
-	Digest      string    `yaml:"digest"`
-	URLs        []string  `yaml:"urls"`
+	Name         string            `yaml:"name" json:"name"`
+	Version      string            `yaml:"version" json:"version"`
+	AppVersion   string            `yaml:"appVersion" json:"appVersion,omitempty"`
+	APIVersion   string            `yaml:"apiVersion" json:"apiVersion"`
+	Type         string            `yaml:"type" json:"type,omitempty"`
+	Created      time.Time         `yaml:"created" json:"created"`
+	Description  string            `yaml:"description" json:"description,omitempty"`
+	Digest       string            `yaml:"digest" json:"digest,omitempty"`
+	URLs         []string          `yaml:"urls" json:"urls"`
+	Home         string            `yaml:"home" json:"home,omitempty"`
+	Icon         string            `yaml:"icon" json:"icon,omitempty"`
+	Sources      []string          `yaml:"sources" json:"sources,omitempty"`
+	Keywords     []string          `yaml:"keywords" json:"keywords,omitempty"`
+	Maintainers  []HelmMaintainer  `yaml:"maintainers" json:"maintainers,omitempty"`
+	KubeVersion  string            `yaml:"kubeVersion" json:"kubeVersion,omitempty"`
+	Condition    string            `yaml:"condition" json:"condition,omitempty"`
+	Tags         string            `yaml:"tags" json:"tags,omitempty"`
+	Deprecated   bool              `yaml:"deprecated" json:"deprecated,omitempty"`
+	Removed      bool              `yaml:"removed" json:"removed,omitempty"`
+	Annotations  map[string]string `yaml:"annotations" json:"annotations,omitempty"`
+	Dependencies []HelmDependency  `yaml:"dependencies" json:"dependencies,omitempty"`
+}
+
+type HelmMaintainer struct {
+	Name  string `yaml:"name" json:"name"`
+	Email string `yaml:"email" json:"email,omitempty"`
+	URL   string `yaml:"url" json:"url,omitempty"`
+}
+
+type HelmDependency struct {
+	Name       string   `yaml:"name" json:"name"`
+	Version    string   `yaml:"version" json:"version,omitempty"`
+	Repository string   `yaml:"repository" json:"repository,omitempty"`
+	Condition  string   `yaml:"condition" json:"condition,omitempty"`
+	Tags       []string `yaml:"tags" json:"tags,omitempty"`
+	Enabled    bool     `yaml:"enabled" json:"enabled,omitempty"`
+	Alias      string   `yaml:"alias" json:"alias,omitempty"`
 }
 
 type HelmChartRepository struct {
@@ -0,0 +1,40 @@
+package resource
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ChartVersion is the version of one of the charts tracked together by a
+// composite version.
+type ChartVersion struct {
+	Chart   string
+	Version string
+}
+
+// CompositeVersion joins the versions of several charts into a single
+// version, such as concourse=11.1.0,postgresql=8.6.4
+func CompositeVersion(versions []ChartVersion) string {
+	pairs := make([]string, 0, len(versions))
+	for _, v := range versions {
+		pairs = append(pairs, v.Chart+"="+v.Version)
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// ParseCompositeVersion splits a composite version into the versions of the
+// charts it is made of.
+func ParseCompositeVersion(version string) ([]ChartVersion, error) {
+	versions := []ChartVersion{}
+	for _, pair := range strings.Split(version, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid composite version %q", version)
+		}
+
+		versions = append(versions, ChartVersion{Chart: parts[0], Version: parts[1]})
+	}
+
+	return versions, nil
+}